@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+const (
+	backupArchiveName = "archive.tar"
+	backupMetaName    = "backup.json"
+)
+
+// Backup holds the metadata for a volume export stored in a backup repository
+type Backup struct {
+	ID          string
+	VolumeID    string
+	Names       []string
+	Created     time.Time
+	Host        string
+	Size        int64
+	Compression string
+	Encrypted   bool `json:",omitempty"`
+}
+
+// backupStorage is where a backup repository keeps its files, with a dir for
+// each backup holding the archive and its metadata
+type backupStorage interface {
+	// Write stores everything read from r as a file in the dir, returning the
+	// size of the file
+	Write(dir, name string, r io.Reader) (int64, error)
+	Open(dir, name string) (io.ReadCloser, error)
+	Dirs() ([]string, error)
+	RemoveAll(dir string) error
+}
+
+// stagingStorage is a backupStorage which can write a backup to a hidden
+// staging dir and move it into place in one go, so a backup never shows up
+// half written and nothing is left behind if writing it is interrupted
+type stagingStorage interface {
+	backupStorage
+	// Stage creates a new staging dir, which Dirs does not list
+	Stage() (string, error)
+	// Commit moves the staging dir into place as dir
+	Commit(staged, dir string) error
+}
+
+type backupRepo struct {
+	storage backupStorage
+}
+
+// newBackupRepo sets up the backup repository at root, which is either a local
+// path or an s3://bucket/prefix URL
+func newBackupRepo(root string, s3 func() (*s3Client, error)) (*backupRepo, error) {
+	if isS3URL(root) {
+		bucket, prefix, err := parseS3URL(root)
+		if err != nil {
+			return nil, err
+		}
+		client, err := s3()
+		if err != nil {
+			return nil, err
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return &backupRepo{&s3Storage{client, bucket, prefix}}, nil
+	}
+
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("Could not create backup repository: %v", err)
+	}
+	return &backupRepo{localStorage(root)}, nil
+}
+
+// Create stores a new backup, with the archive content written out by the
+// passed in func.
+// The backup is only added to the repository once the archive is complete.
+// Storage which supports it gets the whole backup written to a staging dir
+// first, otherwise the metadata is written last, so a backup without it is
+// either still being written or was interrupted.
+func (r *backupRepo) Create(b *Backup, write func(io.Writer) error) error {
+	b.ID = volumes.GenerateRandomID()
+	b.Created = time.Now().UTC()
+
+	dir := b.ID
+	staging, isStaged := r.storage.(stagingStorage)
+	if isStaged {
+		staged, err := staging.Stage()
+		if err != nil {
+			return fmt.Errorf("Could not create backup: %v", err)
+		}
+		defer staging.RemoveAll(staged)
+		dir = staged
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	size, err := r.storage.Write(dir, backupArchiveName, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		r.storage.RemoveAll(dir)
+		return fmt.Errorf("Could not write backup: %v", err)
+	}
+	b.Size = size
+
+	meta, err := json.MarshalIndent(b, "", "	")
+	if err != nil {
+		r.storage.RemoveAll(dir)
+		return err
+	}
+	if _, err := r.storage.Write(dir, backupMetaName, bytes.NewReader(meta)); err != nil {
+		r.storage.RemoveAll(dir)
+		return fmt.Errorf("Could not write backup metadata: %v", err)
+	}
+
+	if isStaged {
+		if err := staging.Commit(dir, b.ID); err != nil {
+			return fmt.Errorf("Could not create backup: %v", err)
+		}
+	}
+	return nil
+}
+
+// List gets all backups in the repository, newest first
+func (r *backupRepo) List() ([]*Backup, error) {
+	dirs, err := r.storage.Dirs()
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []*Backup
+	for _, d := range dirs {
+		b, err := r.load(d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping unreadable backup %s: %v\n", d, err)
+			continue
+		}
+		backups = append(backups, b)
+	}
+	sort.Sort(byCreated(backups))
+	return backups, nil
+}
+
+func (r *backupRepo) load(id string) (*Backup, error) {
+	f, err := r.storage.Open(id, backupMetaName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var b Backup
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Get looks up a backup by ID or a unique prefix of it
+func (r *backupRepo) Get(id string) (*Backup, error) {
+	backups, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Backup
+	for _, b := range backups {
+		if b.ID == id {
+			return b, nil
+		}
+		if strings.HasPrefix(b.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("Backup ID is ambiguous: %s", id)
+			}
+			found = b
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Could not find backup: %s", id)
+	}
+	return found, nil
+}
+
+// Open gets a reader for the backup's archive
+func (r *backupRepo) Open(b *Backup) (io.ReadCloser, error) {
+	return r.storage.Open(b.ID, backupArchiveName)
+}
+
+func (r *backupRepo) Remove(b *Backup) error {
+	return r.storage.RemoveAll(b.ID)
+}
+
+// byCreated sorts backups newest first
+type byCreated []*Backup
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool { return s[i].Created.After(s[j].Created) }
+
+// localStorage keeps backups in a dir on the local filesystem
+type localStorage string
+
+func (s localStorage) Write(dir, name string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Join(string(s), dir), 0700); err != nil {
+		return 0, err
+	}
+
+	// write to a temp file first so a partial file never shows up
+	p := filepath.Join(string(s), dir, name)
+	f, err := os.OpenFile(p+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p + ".tmp")
+		return 0, err
+	}
+	return size, os.Rename(p+".tmp", p)
+}
+
+func (s localStorage) Stage() (string, error) {
+	staged, err := ioutil.TempDir(string(s), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(staged), nil
+}
+
+func (s localStorage) Commit(staged, dir string) error {
+	return os.Rename(filepath.Join(string(s), staged), filepath.Join(string(s), dir))
+}
+
+func (s localStorage) Open(dir, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(s), dir, name))
+}
+
+func (s localStorage) Dirs() ([]string, error) {
+	fis, err := ioutil.ReadDir(string(s))
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, fi := range fis {
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			dirs = append(dirs, fi.Name())
+		}
+	}
+	return dirs, nil
+}
+
+func (s localStorage) RemoveAll(dir string) error {
+	return os.RemoveAll(filepath.Join(string(s), dir))
+}
+
+// s3Storage keeps backups in an S3 bucket under the prefix
+type s3Storage struct {
+	client *s3Client
+	bucket string
+	prefix string
+}
+
+func (s *s3Storage) Write(dir, name string, r io.Reader) (int64, error) {
+	return s.client.Upload(s.bucket, s.prefix+dir+"/"+name, r, nil)
+}
+
+func (s *s3Storage) Open(dir, name string) (io.ReadCloser, error) {
+	return s.client.Download(s.bucket, s.prefix+dir+"/"+name)
+}
+
+func (s *s3Storage) Dirs() ([]string, error) {
+	prefixes, err := s.client.List(s.bucket, s.prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, p := range prefixes {
+		dirs = append(dirs, strings.TrimSuffix(strings.TrimPrefix(p, s.prefix), "/"))
+	}
+	return dirs, nil
+}
+
+func (s *s3Storage) RemoveAll(dir string) error {
+	keys, err := s.client.List(s.bucket, s.prefix+dir+"/", "")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.client.Delete(s.bucket, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
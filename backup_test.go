@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBackupRepoCreateStaged(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := newBackupRepo(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := func() []string {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+
+	var (
+		writing = make(chan struct{})
+		finish  = make(chan struct{})
+		created = make(chan error)
+	)
+	go func() {
+		created <- repo.Create(&Backup{VolumeID: "vol"}, func(w io.Writer) error {
+			if _, err := io.WriteString(w, "partial"); err != nil {
+				return err
+			}
+			close(writing)
+			<-finish
+			_, err := io.WriteString(w, " archive")
+			return err
+		})
+	}()
+
+	<-writing
+	backups, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Fatalf("expected a backup being written to not be listed, got %v", backups)
+	}
+	if names := entries(); len(names) != 1 || names[0][0] != '.' {
+		t.Fatalf("expected only a hidden staging dir while writing, got %v", names)
+	}
+	close(finish)
+	if err := <-created; err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err = repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Size != int64(len("partial archive")) {
+		t.Fatalf("expected the finished backup to be listed, got %v", backups)
+	}
+	if names := entries(); len(names) != 1 || names[0] != backups[0].ID {
+		t.Fatalf("expected only the dir of the backup to be left, got %v", names)
+	}
+}
+
+func TestBackupRepoCreateFailed(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := newBackupRepo(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Create(&Backup{VolumeID: "vol"}, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("export failed")
+	})
+	if err == nil {
+		t.Fatal("expected the failed export to fail the backup")
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 0 {
+		t.Fatalf("expected nothing to be left of the failed backup, got %d entries", len(fis))
+	}
+}
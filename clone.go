@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+func volumeClone(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return fmt.Errorf("Malformed argument. Please supply the source volume and the destination container[:path]")
+	}
+	src, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, src)
+	if err != nil {
+		return err
+	}
+	if store.APIVersion.LessThan("1.20") {
+		return fmt.Errorf("clone needs Docker API 1.20 or newer")
+	}
+
+	v, err := store.Find(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+
+	dst := src
+	if host := ctx.String("to-host"); host != "" {
+		if dst, err = connect(ctx, host); err != nil {
+			return err
+		}
+		ver, err := dst.Version()
+		if err != nil {
+			return fmt.Errorf("Error getting docker daemon version of %s: %v", host, err)
+		}
+		if volumes.APIVersion(ver.ApiVersion).LessThan("1.20") {
+			return fmt.Errorf("clone needs Docker API 1.20 or newer on %s", host)
+		}
+	}
+
+	target, volPath := ctx.Args()[1], v.VolPath
+	if i := strings.Index(target, ":"); i >= 0 {
+		target, volPath = target[:i], target[i+1:]
+	}
+	hostPath, err := containerVolumePath(dst, target, volPath)
+	if err != nil {
+		return err
+	}
+	if dst == src && hostPath == v.HostPath {
+		return fmt.Errorf("Source and destination are the same volume")
+	}
+
+	if ctx.Bool("pause") {
+		volumes.PauseContainers(src, v.Containers)
+		defer volumes.UnpauseContainers(src, v.Containers)
+	}
+
+	if err := volumes.Clone(src, v.HostPath, dst, hostPath); err != nil {
+		return fmt.Errorf("Could not clone volume: %v", err)
+	}
+	return nil
+}
+
+// containerVolumePath gets the host path of the volume at volPath in the
+// container
+func containerVolumePath(docker volumes.Client, name, volPath string) (string, error) {
+	container, err := docker.FetchContainer(name)
+	if err != nil {
+		return "", fmt.Errorf("Could not find container: %s", name)
+	}
+	vols, err := docker.ContainerVolumes(container)
+	if err != nil {
+		return "", fmt.Errorf("Could not get volume listing for container %s: %v", name, err)
+	}
+	vol, exists := vols[volPath]
+	if !exists {
+		return "", fmt.Errorf("Did not find a volume matching the path: %s", volPath)
+	}
+	return volumes.NewVolumeFromDocker(vol).HostPath, nil
+}
@@ -1,206 +1,833 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/cpuguy83/docker-volumes/volumes"
+	"github.com/docker/go-units"
 	"github.com/olekukonko/tablewriter"
 )
 
-func volumeList(ctx *cli.Context) {
-	docker := getDockerClient(ctx)
+func volumeList(ctx *cli.Context) error {
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	filters, err := volumes.ParseFilters(ctx.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+
+	m := volumes.NewManager(docker, loadOptions(ctx))
+	vols, err := m.List(context.Background(), volumes.ListOptions{Filters: filters})
+	if err != nil {
+		return err
+	}
 
-	volumes := setup(docker, ctx.GlobalString("docker-root"))
+	var sizes map[string]volumes.Size
+	withSize := ctx.Bool("size") || ctx.Bool("sort-size")
+	if withSize {
+		sizes, err = volumes.Sizes(docker, vols)
+		if err != nil {
+			return err
+		}
+		if ctx.Bool("sort-size") {
+			sortBySize(vols, sizes)
+		}
+	}
 
 	if ctx.Bool("quiet") {
 		var out []string
-		for _, vol := range volumes.s {
+		for _, vol := range vols {
 			id := vol.ID
 			out = append(out, id)
 		}
 		fmt.Fprintln(os.Stdout, strings.Join(out, "\n"))
-		return
+		return nil
 	}
+	return formatVolumes(os.Stdout, ctx.String("format"), vols, sizes)
+}
+
+func volumeDu(ctx *cli.Context) error {
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, docker)
+	if err != nil {
+		return err
+	}
+
+	var vols []*volumes.Volume
+	if len(ctx.Args()) == 0 {
+		vols = store.List()
+	}
+	for _, name := range ctx.Args() {
+		v, err := store.Find(name)
+		if err != nil {
+			return err
+		}
+		vols = append(vols, v)
+	}
+
+	sizes, err := volumes.Sizes(docker, vols)
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("sort-size") {
+		sortBySize(vols, sizes)
+	}
+
+	formatSize := func(size int64) string {
+		if ctx.Bool("bytes") {
+			return strconv.FormatInt(size, 10)
+		}
+		return units.HumanSize(float64(size))
+	}
+
 	var items [][]string
-	for _, vol := range volumes.s {
+	for _, vol := range vols {
 		id := vol.ID
 		if len(id) > 12 {
 			id = id[:12]
 		}
-		out := []string{id, strings.Join(vol.Names, ", "), vol.HostPath}
-		items = append(items, out)
+		size := sizes[vol.ID]
+		items = append(items, []string{id, strings.Join(vol.Names, ", "), formatSize(size.Apparent), formatSize(size.Disk)})
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"ID", "Names", "Path"})
+	table.SetHeader([]string{"ID", "Names", "Size", "Disk Usage"})
 	table.SetBorder(false)
 	table.AppendBulk(items)
 	table.Render()
+	return nil
 }
 
-func volumeInspect(ctx *cli.Context) {
+func volumeInspect(ctx *cli.Context) error {
 	if len(ctx.Args()) != 1 {
-		fmt.Fprintln(os.Stderr, "Malformed argument. Please supply 1 and only 1 argument")
-		os.Exit(1)
+		return fmt.Errorf("Malformed argument. Please supply 1 and only 1 argument")
 	}
 
-	docker := getDockerClient(ctx)
-	volumes := setup(docker, ctx.GlobalString("docker-root"))
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, docker)
+	if err != nil {
+		return err
+	}
 
-	v := volumes.Find(ctx.Args()[0])
-	vJson, err := json.MarshalIndent(v, "", "	")
+	name := ctx.Args()[0]
+	v, err := store.Find(name)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "error marshalling volume data: %v", err)
-		os.Exit(1)
+		return err
 	}
 
-	fmt.Println(string(vJson))
+	format := ctx.String("format")
+	if format == "" {
+		format = "json"
+	}
+	if format == "json" {
+		// keep inspect output as a single object rather than a list
+		return writeJSON(os.Stdout, v)
+	}
+	return formatVolumes(os.Stdout, format, []*volumes.Volume{v}, nil)
 }
 
-func volumeRm(ctx *cli.Context) {
+func volumeRm(ctx *cli.Context) error {
 	if len(ctx.Args()) == 0 {
-		fmt.Fprintln(os.Stderr, "Malformed argument. Must supply at least 1 argument")
-		os.Exit(1)
+		return fmt.Errorf("Malformed argument. Must supply at least 1 argument")
 	}
 
-	docker := getDockerClient(ctx)
-	volumes := setup(docker, ctx.GlobalString("docker-root"))
-	for _, name := range ctx.Args() {
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, docker)
+	if err != nil {
+		return err
+	}
 
-		v := volumes.Find(name)
-		if v == nil {
-			fmt.Fprintln(os.Stderr, "Could not find volume: ", name)
+	// Everything is looked up before anything is removed, so a typo or an
+	// ambiguous ID shows up in the preview
+	var (
+		errs  []error
+		vols  []*volumes.Volume
+		items [][]string
+		seen  = make(map[string]bool)
+	)
+	for _, name := range ctx.Args() {
+		v, err := store.Find(name)
+		if err != nil {
+			errs = append(errs, err)
+			items = append(items, []string{"", name, "fail: " + err.Error()})
 			continue
 		}
-		if !volumes.CanRemove(v) {
-			fmt.Fprintln(os.Stderr, "Volume is in use, cannot remove: ", name)
+		if seen[v.ID] {
 			continue
 		}
+		seen[v.ID] = true
 
-		var containerConfig map[string]interface{}
+		action, err := rmAction(ctx, docker, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			action = "fail: " + err.Error()
+		} else {
+			vols = append(vols, v)
+		}
+		items = append(items, []string{v.ID, strings.Join(v.Names, "\n"), action})
+	}
 
-		if dockerApiVersion.LessThan("1.19") {
+	if len(items) > 0 && (ctx.Bool("dry-run") || !ctx.Bool("yes")) {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Names", "Action"})
+		table.SetBorder(false)
+		table.AppendBulk(items)
+		table.Render()
+	}
+	if ctx.Bool("dry-run") {
+		fmt.Printf("Would remove %d volumes\n", len(vols))
+		return errors.Join(errs...)
+	}
+	if len(vols) > 0 && !ctx.Bool("yes") {
+		if !stdinIsTerminal() {
+			return errors.Join(append(errs, errors.New("Not removing any volumes, stdin is not a terminal to confirm on, use --yes"))...)
+		}
+		if !confirm(fmt.Sprintf("Remove these %d volumes?", len(vols))) {
+			return errors.Join(append(errs, errors.New("Not removing any volumes, not confirmed"))...)
+		}
+	}
 
-			hostMountPath := strings.TrimSuffix(v.HostPath, path.Base(v.HostPath))
-			hostConfPath := strings.TrimSuffix(hostMountPath, "/vfs/dir/") + "/volumes"
+	for _, v := range vols {
+		if err := removeVolume(ctx, store, docker, v); err != nil {
+			errs = append(errs, fmt.Errorf("Could not remove volume %s: %w", v.ID, err))
+			continue
+		}
+		fmt.Println("Removed volume:", v.ID)
+	}
+	return errors.Join(errs...)
+}
 
-			bindSpec := hostMountPath + ":" + "/.dockervolume"
-			bindSpec2 := hostConfPath + ":" + "/.dockervolume2"
-			containerConfig = map[string]interface{}{
-				"Image":      "busybox:latest",
-				"Entrypoint": []string{"/bin/sh", "-c"},
-				"Cmd":        []string{"rm -rf /.dockervolume/" + path.Base(v.HostPath) + ("&& rm -rf /.dockervolume2/" + path.Base(v.HostPath))},
-				"HostConfig": map[string]interface{}{
-					"Binds": []string{bindSpec, bindSpec2},
-				},
-			}
-		} else {
-			hostMountPath := strings.TrimSuffix(v.HostPath, path.Base(v.HostPath))
-			hostMountPath = strings.TrimSuffix(v.HostPath, path.Base(v.HostPath))
-			bindSpec := hostMountPath + ":" + "/.dockervolume"
-			containerConfig = map[string]interface{}{
-				"Image":      "busybox:latest",
-				"Entrypoint": []string{"/bin/sh", "-c"},
-				"Cmd":        []string{"rm -rf /.dockervolume/" + path.Base(v.HostPath)},
-				"HostConfig": map[string]interface{}{
-					"Binds": []string{bindSpec},
-				},
-			}
+// rmAction describes what rm does to the volume, an error is returned if it
+// can't be removed
+func rmAction(ctx *cli.Context, docker volumes.Client, v *volumes.Volume) (string, error) {
+	if v.IsBindMount {
+		return "", fmt.Errorf("%s is a bind-mount of %s, not a volume", v.ID, v.HostPath)
+	}
+	if len(v.Containers) == 0 {
+		return "remove", nil
+	}
+	if !ctx.Bool("force") {
+		return "", fmt.Errorf("%w: used by %s, use --force to remove the containers if they are stopped", volumes.ErrInUse, strings.Join(containerNames(v), ", "))
+	}
+	running, err := volumes.RunningContainers(docker, v)
+	if err != nil {
+		return "", err
+	}
+	if len(running) > 0 {
+		return "", fmt.Errorf("%w: used by running containers %s", volumes.ErrInUse, strings.Join(running, ", "))
+	}
+	return "remove with containers " + strings.Join(containerNames(v), ", "), nil
+}
+
+// removeVolume removes the volume, with --force the containers using it are
+// removed first
+func removeVolume(ctx *cli.Context, store *volumes.Store, docker volumes.Client, v *volumes.Volume) error {
+	if ctx.Bool("force") && len(v.Containers) > 0 {
+		names := containerNames(v)
+		if err := store.RemoveContainers(docker, v); err != nil {
+			return err
+		}
+		fmt.Println("Removed containers:", strings.Join(names, ", "))
+	}
+	return store.Remove(docker, []*volumes.Volume{v})
+}
+
+// containerNames gets the names of the containers using the volume
+func containerNames(v *volumes.Volume) []string {
+	var names []string
+	for _, n := range v.Names {
+		names = append(names, strings.SplitN(n, ":", 2)[0])
+	}
+	return names
+}
+
+func volumePrune(ctx *cli.Context) error {
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, docker)
+	if err != nil {
+		return err
+	}
+
+	var dangling []*volumes.Volume
+	for _, v := range store.List() {
+		if store.CanRemove(v) {
+			dangling = append(dangling, v)
+		}
+	}
+	if len(dangling) == 0 {
+		fmt.Println("No dangling volumes to remove")
+		return nil
+	}
+
+	sizes, err := volumes.Sizes(docker, dangling)
+	if err != nil {
+		return err
+	}
+
+	var (
+		items [][]string
+		total int64
+	)
+	for _, v := range dangling {
+		size := sizes[v.ID].Disk
+		total += size
+		items = append(items, []string{v.ID, v.HostPath, units.HumanSize(float64(size))})
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Path", "Size"})
+	table.SetBorder(false)
+	table.AppendBulk(items)
+	table.Render()
+
+	if ctx.Bool("dry-run") {
+		fmt.Printf("Would remove %d volumes, reclaiming %s\n", len(dangling), units.HumanSize(float64(total)))
+		return nil
+	}
+
+	if !ctx.Bool("force") {
+		if !stdinIsTerminal() {
+			return errors.New("Not removing any volumes, stdin is not a terminal to confirm on, use --force")
+		}
+		if !confirm(fmt.Sprintf("Remove these %d volumes?", len(dangling))) {
+			return errors.New("Not removing any volumes, not confirmed")
 		}
+	}
+
+	if err := store.Remove(docker, dangling); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d volumes, total reclaimed space: %s\n", len(dangling), units.HumanSize(float64(total)))
+	return nil
+}
 
-		containerId, err := docker.RunContainer(containerConfig)
+func volumeExport(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("Malformed argument. Please supply 1 and only 1 argument")
+	}
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, docker)
+	if err != nil {
+		return err
+	}
+
+	name := ctx.Args()[0]
+	v, err := store.Find(name)
+	if err != nil {
+		return err
+	}
+
+	if to := ctx.String("to"); to != "" {
+		return exportVolumeToS3(ctx, docker, store, v, to)
+	}
+	return exportVolume(ctx, docker, store, v, os.Stdout)
+}
+
+// exportVolumeToS3 uploads the export archive to an s3://bucket/key URL.
+// If the key is a prefix, ie. empty or ending in a `/`, a name is generated
+// from the volume ID and the current time.
+func exportVolumeToS3(ctx *cli.Context, docker volumes.Client, store *volumes.Store, v *volumes.Volume, to string) error {
+	bucket, key, err := parseS3URL(to)
+	if err != nil {
+		return err
+	}
+	client, err := getS3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	compression := ctx.String("compress")
+	if key == "" || strings.HasSuffix(key, "/") {
+		id := v.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		key += fmt.Sprintf("%s-%s%s", id, time.Now().UTC().Format("20060102T150405Z"), archiveExtension(compression))
+		if encryptionRequested(ctx) {
+			key += ".enc"
+		}
+	}
+
+	meta := map[string]string{
+		"Volume-Id":    v.ID,
+		"Volume-Names": strings.Join(v.Names, ","),
+		"Compression":  compression,
+		"Docker-Host":  ctx.GlobalString("host"),
+		"Encrypted":    strconv.FormatBool(encryptionRequested(ctx)),
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(exportVolume(ctx, docker, store, v, pw))
+	}()
+	_, err = client.Upload(bucket, key, pr, meta)
+	pr.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("Could not upload export archive: %v", err)
+	}
+	fmt.Printf("s3://%s/%s\n", bucket, key)
+	return nil
+}
+
+// exportVolume writes the export archive for the volume to w, taking care of
+// pausing containers and compression as requested by the command's flags
+func exportVolume(ctx *cli.Context, docker volumes.Client, store *volumes.Store, v *volumes.Volume, w io.Writer) error {
+	key, err := encryptionKeyFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("encrypt") && key == nil {
+		return fmt.Errorf("--encrypt needs one of --encryption-key, --recipient or --passphrase-file")
+	}
+	var enc io.WriteCloser = nopWriteCloser{w}
+	if key != nil {
+		if enc, err = encryptStream(w, key); err != nil {
+			return fmt.Errorf("Could not encrypt export archive: %v", err)
+		}
+	}
+
+	opts := volumes.ExportOptions{
+		Pause:        ctx.Bool("pause"),
+		NumericOwner: ctx.Bool("numeric-owner"),
+		XattrsImage:  ctx.String("xattrs-image"),
+	}
+	if p := ctx.String("sign-key"); p != "" {
+		if opts.SignKey, err = loadSigningKey(p); err != nil {
+			return err
+		}
+	}
+	if p := ctx.String("since"); p != "" {
+		if opts.Base, err = loadBaseManifest(p, key); err != nil {
+			return fmt.Errorf("Could not read base for incremental export: %v", err)
+		}
+	}
+
+	out, err := compressStream(enc, ctx.String("compress"))
+	if err != nil {
+		return err
+	}
+
+	if err := store.Export(context.Background(), docker, v, out, opts); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("Could not write export archive: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("Could not write export archive: %v", err)
+	}
+	return nil
+}
+
+// encryptionRequested checks if the export is to be encrypted
+func encryptionRequested(ctx *cli.Context) bool {
+	return ctx.Bool("encrypt") || ctx.String("encryption-key") != "" || ctx.String("recipient") != "" || ctx.String("passphrase-file") != ""
+}
+
+// encryptionKeyFromFlags loads the key to encrypt or decrypt archives with,
+// nil is returned if no key was given
+func encryptionKeyFromFlags(ctx *cli.Context) (*encryptionKey, error) {
+	var keys []*encryptionKey
+	if p := ctx.String("encryption-key"); p != "" {
+		key, err := loadEncryptionKey(p)
 		if err != nil {
-			docker.RemoveContainer(containerId, true, true)
-			fmt.Fprintln(os.Stderr, "Could not remove volume: ", v.HostPath)
-			continue
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if r := ctx.String("recipient"); r != "" {
+		key, err := parseRecipient(r)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if p := ctx.String("passphrase-file"); p != "" {
+		passphrase, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase: %v", err)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("passphrase file is empty")
+		}
+		keys = append(keys, &encryptionKey{passphrase: passphrase})
+	}
+
+	switch len(keys) {
+	case 0:
+		return nil, nil
+	case 1:
+		return keys[0], nil
+	default:
+		return nil, fmt.Errorf("only one of --encryption-key, --recipient or --passphrase-file can be used")
+	}
+}
+
+func volumeImport(ctx *cli.Context) error {
+	from := ctx.String("from")
+	if from == "" {
+		return importArchive(ctx, os.Stdin, ctx.Args())
+	}
+
+	bucket, key, err := parseS3URL(from)
+	if err != nil {
+		return err
+	}
+	client, err := getS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	arch, err := client.Download(bucket, key)
+	if err != nil {
+		return fmt.Errorf("Could not download import archive: %v", err)
+	}
+	defer arch.Close()
+	return importArchive(ctx, arch, ctx.Args())
+}
+
+// importArchive imports an archive produced by export into the container
+// named by the first arg, at the volume path given by the optional second arg.
+// Incremental archives given with --increment are applied on top, in order.
+func importArchive(ctx *cli.Context, in io.Reader, args []string) error {
+	newVolume := ctx.Bool("new-volume")
+	if len(args) < 1 && !newVolume {
+		return fmt.Errorf("Missing container")
+	}
+	if len(args) > 0 && newVolume {
+		return fmt.Errorf("Cannot import to both a container and a new volume")
+	}
+	increments := ctx.StringSlice("increment")
+	if len(increments) > 0 && ctx.Bool("skip-verify") {
+		return fmt.Errorf("Cannot use --increment with --skip-verify, the manifests are needed to check the chain")
+	}
+	var verifyKey ed25519.PublicKey
+	if k := ctx.String("verify-key"); k != "" {
+		if ctx.Bool("skip-verify") {
+			return fmt.Errorf("Cannot use --verify-key with --skip-verify")
+		}
+		var err error
+		if verifyKey, err = loadVerifyKey(k); err != nil {
+			return err
+		}
+	}
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := loadApiVersion(docker); err != nil {
+		return err
+	}
+	key, err := encryptionKeyFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Check the whole chain of increments up front so a broken chain is
+	// caught before anything is written to the volume
+	chain, err := verifyIncrements(increments, key, verifyKey)
+	if err != nil {
+		return fmt.Errorf("Refusing to import archive: %v", err)
+	}
+
+	if dockerApiVersion.LessThan("1.20") {
+		return importArchiveLegacy(ctx, docker, in, args, key, verifyKey, chain)
+	}
+
+	var target string
+	if !newVolume {
+		target = args[0]
+	}
+	owners, err := importOwnerMap(ctx, docker, target)
+	if err != nil {
+		return err
+	}
+
+	src, err := openImportSource(ctx, in, key, verifyKey, owners)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if len(chain) > 0 && chain[0].Base != src.Manifest.ID {
+		return fmt.Errorf("Refusing to import archive: first increment is not based on the imported archive")
+	}
+
+	var (
+		hostPath    string
+		newVolumeID string
+		volPath     string
+	)
+	if len(args) > 1 {
+		volPath = args[1]
+	} else if newVolume && ctx.String("path") != "" {
+		volPath = ctx.String("path")
+	} else if src.Config != nil {
+		volPath = src.Config.VolPath
+	} else {
+		return fmt.Errorf("Archive has no volume config, the volume path has to be given")
+	}
+
+	if newVolume {
+		vol, err := volumes.CreateVolume(docker, volPath)
+		if err != nil {
+			return fmt.Errorf("Could not create volume: %v", err)
 		}
-		defer docker.RemoveContainer(containerId, true, true)
-		docker.ContainerWait(containerId)
-		c, err := docker.FetchContainer(containerId)
+		v := volumes.NewVolumeFromDocker(vol)
+		hostPath, newVolumeID = v.HostPath, v.ID
+	} else {
+		hostPath, err = containerVolumePath(docker, args[0], volPath)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error getting removeal state")
-			os.Exit(1)
+			return err
 		}
+	}
+
+	if err := src.Extract(docker, hostPath); err != nil {
+		return fmt.Errorf("Could not import data: %v", err)
+	}
 
-		if c.State.ExitCode != 0 {
-			fmt.Fprintln(os.Stderr, "Could not remove volume ", v.HostPath)
-			fmt.Println(os.Stderr, c.State.Error)
+	for i, p := range increments {
+		if err := applyIncrement(ctx, docker, p, chain[i], hostPath, key, verifyKey, owners); err != nil {
+			return fmt.Errorf("Could not apply increment %s: %v", p, err)
 		}
+	}
 
-		fmt.Println("Successfully removed volume: ", name)
+	if newVolumeID != "" {
+		fmt.Println(newVolumeID)
 	}
+	return nil
 }
 
-func volumeExport(ctx *cli.Context) {
-	if len(ctx.Args()) != 1 {
-		fmt.Fprintln(os.Stderr, "Malformed argument. Please supply 1 and only 1 argument")
-		os.Exit(1)
+// applyIncrement imports an incremental archive into the volume at hostPath,
+// the archive has to be the one from the chain which was checked up front
+func applyIncrement(ctx *cli.Context, docker volumes.Client, p string, expected *volumes.Manifest, hostPath string, key *encryptionKey, verifyKey ed25519.PublicKey, owners *volumes.OwnerMap) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
 	}
-	docker := getDockerClient(ctx)
-	volumes := setup(docker, ctx.GlobalString("docker-root"))
+	defer f.Close()
 
-	name := ctx.Args()[0]
-	v := volumes.Find(name)
-	if v == nil {
-		fmt.Fprintln(os.Stderr, "Could not find volume: ", name)
-		os.Exit(1)
+	src, err := openImportSource(ctx, f, key, verifyKey, owners)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if src.Manifest.ID != expected.ID {
+		return fmt.Errorf("archive changed since it was checked")
 	}
+	return src.Extract(docker, hostPath)
+}
 
-	pause := ctx.Bool("pause")
-	unpause := func() {
-		if pause {
-			for _, c := range v.Containers {
-				docker.ContainerUnpause(c)
-			}
+// openImportSource decrypts and decompresses the archive and gets it ready for
+// import, checking it against its manifest unless --skip-verify is set.
+// Encrypted archives are spooled as they are, so the decrypted data never
+// reaches the disk.
+func openImportSource(ctx *cli.Context, in io.Reader, key *encryptionKey, verifyKey ed25519.PublicKey, owners *volumes.OwnerMap) (*volumes.ImportSource, error) {
+	if key != nil && !ctx.Bool("skip-verify") {
+		arch, m, err := spoolEncrypted(in, key, verifyKey)
+		if err != nil {
+			return nil, err
+		}
+		src, err := volumes.OpenImport(arch, volumes.ImportOptions{SkipVerify: true, Owners: owners})
+		if err != nil {
+			return nil, err
 		}
+		src.Manifest = m
+		return src, nil
 	}
-	if pause {
-		pauseContainers(docker, v.Containers)
-		defer unpause()
+
+	in, err := decryptStream(in, key)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read import archive: %v", err)
+	}
+	arch, err := decompressStream(in)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read import archive: %v", err)
 	}
+	return volumes.OpenImport(arch, volumes.ImportOptions{
+		SkipVerify: ctx.Bool("skip-verify"),
+		VerifyKey:  verifyKey,
+		Owners:     owners,
+	})
+}
 
-	arch, err := copyForExport(docker, v)
+// spoolEncrypted copies the encrypted archive to a temp file as it arrives,
+// checking it against its manifest and signature by decrypting it on the way.
+// Once it passed, the archive is decrypted again from the temp file, which is
+// already unlinked and is closed along with the returned reader.
+func spoolEncrypted(in io.Reader, key *encryptionKey, verifyKey ed25519.PublicKey) (io.ReadCloser, *volumes.Manifest, error) {
+	f, err := ioutil.TempFile("", "docker-volumes-import")
 	if err != nil {
-		unpause()
-		fmt.Fprintln(os.Stderr, "Could not create export archive: ", err)
-		os.Exit(1)
+		return nil, nil, err
 	}
-	io.Copy(os.Stdout, arch)
+	os.Remove(f.Name())
+
+	m, err := verifyEncrypted(io.TeeReader(in, f), key, verifyKey)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	dec, err := decryptStream(f, key)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("Could not read import archive: %v", err)
+	}
+	arch, err := decompressStream(dec)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("Could not read import archive: %v", err)
+	}
+	return &spooledArchive{arch, f}, m, nil
 }
 
-func volumeImport(ctx *cli.Context) {
-	if len(ctx.Args()) < 1 {
-		fmt.Fprintln(os.Stderr, "Missing container")
+// verifyEncrypted decrypts the archive and checks it against its manifest.
+// All of in is read, so the whole archive ends up in the spool.
+func verifyEncrypted(in io.Reader, key *encryptionKey, verifyKey ed25519.PublicKey) (*volumes.Manifest, error) {
+	dec, err := decryptStream(in, key)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read import archive: %v", err)
 	}
-	docker := getDockerClient(ctx)
-	buildContext := bufio.NewReader(os.Stdin)
+	arch, err := decompressStream(dec)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read import archive: %v", err)
+	}
+	defer arch.Close()
 
-	importToName := ctx.Args()[0]
-	container, err := docker.FetchContainer(importToName)
+	m, err := volumes.VerifyArchive(arch, verifyKey)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not find container to import to:", importToName)
-		os.Exit(1)
+		return nil, fmt.Errorf("Refusing to import archive: %v", err)
+	}
+	// the tail of the stream past the end of the tar has to be decrypted too,
+	// so a truncated archive does not get through
+	if _, err := io.Copy(ioutil.Discard, arch); err != nil {
+		return nil, fmt.Errorf("Refusing to import archive: %v", err)
+	}
+	if _, err := io.Copy(ioutil.Discard, in); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// spooledArchive is an archive read from a temp file, which is closed with it
+type spooledArchive struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (a *spooledArchive) Close() error {
+	a.ReadCloser.Close()
+	return a.f.Close()
+}
+
+// importOwnerMap sets up the ownership rewriting from the command's flags,
+// user and group names are looked up in the container being imported to
+func importOwnerMap(ctx *cli.Context, docker volumes.Client, container string) (*volumes.OwnerMap, error) {
+	m := &volumes.OwnerMap{Numeric: ctx.Bool("numeric-owner")}
+	var err error
+	if m.UIDs, err = parseIDMap(ctx.StringSlice("map-uid")); err != nil {
+		return nil, err
+	}
+	if m.GIDs, err = parseIDMap(ctx.StringSlice("map-gid")); err != nil {
+		return nil, err
 	}
+	if !m.Numeric && container != "" {
+		// a container without passwd or group files only has numeric IDs
+		m.Users, m.Groups, _ = volumes.ContainerOwners(docker, container)
+	}
+	return m, nil
+}
 
-	imgId, err := buildImportImage(docker, buildContext, importToName)
+// importArchiveLegacy imports the archive by building an image from it, which
+// copies the data into the volume when run. This is only used for daemons
+// older than API 1.20, which have no archive upload API.
+func importArchiveLegacy(ctx *cli.Context, docker volumes.Client, in io.Reader, args []string, key *encryptionKey, verifyKey ed25519.PublicKey, chain []*volumes.Manifest) error {
+	newVolume := ctx.Bool("new-volume")
+	var (
+		importToName string
+		container    *volumes.Container
+		err          error
+	)
+	if !newVolume {
+		importToName = args[0]
+		container, err = docker.FetchContainer(importToName)
+		if err != nil {
+			return fmt.Errorf("Could not find container to import to: %s", importToName)
+		}
+	}
+
+	imgId, manifest, err := buildVerifiedImage(ctx, docker, in, importToName, key, verifyKey)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not create import: %s", err)
+		return err
 	}
 	defer docker.RemoveImage(imgId, true, false)
 
-	var copyToVolDir string
-	if len(ctx.Args()) > 1 {
+	if len(chain) > 0 && chain[0].Base != manifest.ID {
+		docker.RemoveImage(imgId, true, false)
+		return fmt.Errorf("Refusing to import archive: first increment is not based on the imported archive")
+	}
+
+	var (
+		copyToVolDir string
+		newVolumeID  string
+	)
+	if newVolume {
+		volPath := ctx.String("path")
+		if volPath == "" {
+			volPath, err = volumes.ExtractVolumePath(docker, imgId)
+			if err != nil {
+				docker.RemoveImage(imgId, true, false)
+				return err
+			}
+		}
+
+		vol, err := volumes.CreateVolume(docker, volPath)
+		if err != nil {
+			docker.RemoveImage(imgId, true, false)
+			return fmt.Errorf("Could not create volume: %v", err)
+		}
+		copyToVolDir = vol.HostPath
+		newVolumeID = volumes.NewVolumeFromDocker(vol).ID
+	}
+
+	if len(args) > 1 {
 		// The user asked for the volume to be put in a sepcific dir
 		// Let's pull that container and see if there is a volume at that location
-		vols, _ := container.GetVolumes()
+		vols, _ := docker.ContainerVolumes(container)
 		for path, vol := range vols {
-			if path == ctx.Args()[1] {
+			if path == args[1] {
 				copyToVolDir = vol.HostPath
 				break
 			}
@@ -209,8 +836,7 @@ func volumeImport(ctx *cli.Context) {
 		// matching the one passed in
 		if copyToVolDir == "" {
 			docker.RemoveImage(imgId, true, false)
-			fmt.Fprintln(os.Stderr, "Did not find a volume matching the path: ", ctx.Args()[1])
-			os.Exit(1)
+			return fmt.Errorf("Did not find a volume matching the path: %s", args[1])
 		}
 	}
 
@@ -219,17 +845,16 @@ func volumeImport(ctx *cli.Context) {
 		// We could untar the archive from the build context manually, but if it is a
 		// large volume, this would not be ideal, especially since now it is already
 		// baked into an image
-		volPath, err := extractVolConfigJson(imgId, docker)
+		volPath, err := volumes.ExtractVolumePath(docker, imgId)
 		if err != nil {
 			docker.RemoveImage(imgId, true, false)
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			return err
 		}
 
-		vols, err := container.GetVolumes()
+		vols, err := docker.ContainerVolumes(container)
 		if err != nil {
 			docker.RemoveImage(imgId, true, false)
-			fmt.Fprintln(os.Stderr, "Could not get volume listing for container ", importToName, ": ", err)
+			return fmt.Errorf("Could not get volume listing for container %s: %v", importToName, err)
 		}
 
 		for _, v := range vols {
@@ -240,26 +865,377 @@ func volumeImport(ctx *cli.Context) {
 		}
 		if copyToVolDir == "" {
 			docker.RemoveImage(imgId, true, false)
-			fmt.Fprintln(os.Stderr, "Did not find a volume matching the path: ", volPath)
-			os.Exit(1)
+			return fmt.Errorf("Did not find a volume matching the path: %s", volPath)
+		}
+	}
+
+	if err := volumes.RunImportImage(docker, imgId, copyToVolDir); err != nil {
+		return fmt.Errorf("Could not import data: %v", err)
+	}
+
+	for i, p := range ctx.StringSlice("increment") {
+		if err := applyIncrementLegacy(ctx, docker, p, chain[i], importToName, copyToVolDir, key, verifyKey); err != nil {
+			return fmt.Errorf("Could not apply increment %s: %v", p, err)
+		}
+	}
+
+	if newVolumeID != "" {
+		fmt.Println(newVolumeID)
+	}
+	return nil
+}
+
+// buildVerifiedImage builds the import image from the archive, verifying it
+// against its manifest, and its signature if a key is passed, on the way.
+// The image is removed again if the archive does not pass.
+func buildVerifiedImage(ctx *cli.Context, docker volumes.Client, in io.Reader, name string, key *encryptionKey, verifyKey ed25519.PublicKey) (string, *volumes.Manifest, error) {
+	var (
+		buildContext io.ReadCloser
+		spooled      *volumes.Manifest
+		err          error
+	)
+	if key != nil && verifyKey != nil {
+		// keep the decrypted archive off the disk while the signature is checked
+		if buildContext, spooled, err = spoolEncrypted(in, key, verifyKey); err != nil {
+			return "", nil, err
+		}
+	} else {
+		if in, err = decryptStream(in, key); err != nil {
+			return "", nil, fmt.Errorf("Could not read import archive: %v", err)
+		}
+		if buildContext, err = decompressStream(in); err != nil {
+			return "", nil, fmt.Errorf("Could not read import archive: %v", err)
+		}
+	}
+	defer buildContext.Close()
+
+	var (
+		importContext io.Reader = buildContext
+		verified      <-chan volumes.VerifyResult
+		manifest      = &volumes.Manifest{}
+	)
+	switch {
+	case spooled != nil:
+		manifest = spooled
+	case verifyKey != nil:
+		// Nothing from the archive may reach the daemon before the signature is
+		// checked, which is only possible once the whole archive has been read
+		f, m, err := volumes.SpoolVerified(buildContext, verifyKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("Refusing to import archive: %v", err)
+		}
+		defer f.Close()
+		importContext = f
+		manifest = m
+	case !ctx.Bool("skip-verify"):
+		importContext, verified = volumes.VerifyingReader(buildContext)
+	}
+
+	imgId, err := volumes.BuildImportImage(docker, importContext, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not create import: %v", err)
+	}
+
+	if verified != nil {
+		// Make sure the whole archive has gone through the verifier
+		io.Copy(ioutil.Discard, importContext)
+		res := <-verified
+		if res.Err != nil {
+			docker.RemoveImage(imgId, true, false)
+			return "", nil, fmt.Errorf("Refusing to import archive: %v", res.Err)
 		}
+		manifest = res.Manifest
 	}
+	return imgId, manifest, nil
+}
 
-	bindSpec := fmt.Sprintf("%s:/.dockervolume", copyToVolDir)
-	containerConfig := map[string]interface{}{
-		"Image": imgId,
-		"HostConfig": map[string]interface{}{
-			"Binds": []string{bindSpec},
-		},
+// applyIncrementLegacy is applyIncrement for daemons without the archive API
+func applyIncrementLegacy(ctx *cli.Context, docker volumes.Client, p string, expected *volumes.Manifest, name, hostPath string, key *encryptionKey, verifyKey ed25519.PublicKey) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
 	}
-	id, err := docker.RunContainer(containerConfig)
+	defer f.Close()
+
+	imgId, m, err := buildVerifiedImage(ctx, docker, f, name, key, verifyKey)
 	if err != nil {
+		return err
+	}
+	if m.ID != expected.ID {
 		docker.RemoveImage(imgId, true, false)
-		docker.RemoveContainer(id, true, true)
-		fmt.Fprintln(os.Stderr, "Could not import data: ", err)
-		os.Exit(1)
+		return fmt.Errorf("archive changed since it was checked")
+	}
+	return volumes.RunImportImage(docker, imgId, hostPath)
+}
+
+func volumeVerify(ctx *cli.Context) error {
+	var verifyKey ed25519.PublicKey
+	if k := ctx.String("verify-key"); k != "" {
+		var err error
+		if verifyKey, err = loadVerifyKey(k); err != nil {
+			return err
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if len(ctx.Args()) > 0 {
+		f, err := os.Open(ctx.Args()[0])
+		if err != nil {
+			return fmt.Errorf("Could not open archive: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	key, err := encryptionKeyFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	in, err = decryptStream(in, key)
+	if err != nil {
+		return fmt.Errorf("Could not read archive: %v", err)
+	}
+	arch, err := decompressStream(in)
+	if err != nil {
+		return fmt.Errorf("Could not read archive: %v", err)
+	}
+	defer arch.Close()
+
+	m, err := volumes.VerifyArchive(arch, verifyKey)
+	if err != nil {
+		return fmt.Errorf("Archive failed verification: %v", err)
+	}
+	n := len(m.Files)
+	if verifyKey != nil {
+		fmt.Printf("Archive OK, verified %d files signed by %s\n", n, volumes.KeyID(verifyKey))
+		return nil
+	}
+	fmt.Printf("Archive OK, verified %d files\n", n)
+	return nil
+}
+
+func getS3Client(ctx *cli.Context) (*s3Client, error) {
+	return newS3Client(ctx.GlobalString("s3-endpoint"), ctx.GlobalString("s3-region"))
+}
+
+func getBackupRepo(ctx *cli.Context) (*backupRepo, error) {
+	return newBackupRepo(ctx.GlobalString("backup-dir"), func() (*s3Client, error) {
+		return getS3Client(ctx)
+	})
+}
+
+func volumeBackup(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("Malformed argument. Please supply 1 and only 1 argument")
+	}
+	repo, err := getBackupRepo(ctx)
+	if err != nil {
+		return err
+	}
+	docker, err := getDockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := loadVolumes(ctx, docker)
+	if err != nil {
+		return err
+	}
+
+	name := ctx.Args()[0]
+	v, err := store.Find(name)
+	if err != nil {
+		return err
+	}
+
+	b := &Backup{
+		VolumeID:    v.ID,
+		Names:       v.Names,
+		Host:        ctx.GlobalString("host"),
+		Compression: ctx.String("compress"),
+		Encrypted:   encryptionRequested(ctx),
+	}
+	err = repo.Create(b, func(w io.Writer) error {
+		return exportVolume(ctx, docker, store, v, w)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(b.ID)
+	return nil
+}
+
+func backupList(ctx *cli.Context) error {
+	repo, err := getBackupRepo(ctx)
+	if err != nil {
+		return err
+	}
+	backups, err := repo.List()
+	if err != nil {
+		return fmt.Errorf("Could not list backups: %v", err)
+	}
+
+	if ctx.Bool("quiet") {
+		for _, b := range backups {
+			fmt.Println(b.ID)
+		}
+		return nil
+	}
+
+	var items [][]string
+	for _, b := range backups {
+		volId := b.VolumeID
+		if len(volId) > 12 {
+			volId = volId[:12]
+		}
+		items = append(items, []string{
+			b.ID[:12],
+			volId,
+			strings.Join(b.Names, ", "),
+			b.Created.Local().Format(time.RFC3339),
+			b.Host,
+			units.HumanSize(float64(b.Size)),
+		})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Volume", "Names", "Created", "Host", "Size"})
+	table.SetBorder(false)
+	table.AppendBulk(items)
+	table.Render()
+	return nil
+}
+
+func backupRm(ctx *cli.Context) error {
+	if len(ctx.Args()) == 0 {
+		return fmt.Errorf("Malformed argument. Must supply at least 1 argument")
+	}
+	repo, err := getBackupRepo(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, id := range ctx.Args() {
+		b, err := repo.Get(id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		if err := repo.Remove(b); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not remove backup", id, ":", err)
+			failed++
+			continue
+		}
+		fmt.Println(b.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Could not remove %d of %d backups", failed, len(ctx.Args()))
+	}
+	return nil
+}
+
+func backupPrune(ctx *cli.Context) error {
+	policy := retentionPolicy{
+		Last:    ctx.Int("keep-last"),
+		Daily:   ctx.Int("keep-daily"),
+		Weekly:  ctx.Int("keep-weekly"),
+		Monthly: ctx.Int("keep-monthly"),
+	}
+	if policy.Empty() {
+		return fmt.Errorf("No retention policy given, refusing to remove all backups. Use at least one of the --keep options")
+	}
+
+	repo, err := getBackupRepo(ctx)
+	if err != nil {
+		return err
+	}
+	backups, err := repo.List()
+	if err != nil {
+		return fmt.Errorf("Could not list backups: %v", err)
+	}
+
+	_, remove := policy.Apply(backups)
+	if len(remove) == 0 {
+		fmt.Println("No backups to remove")
+		return nil
+	}
+
+	if ctx.Bool("dry-run") {
+		var items [][]string
+		for _, b := range remove {
+			items = append(items, []string{b.ID[:12], backupGroup(b), b.Created.Local().Format(time.RFC3339), units.HumanSize(float64(b.Size))})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Volume", "Created", "Size"})
+		table.SetBorder(false)
+		table.AppendBulk(items)
+		table.Render()
+		fmt.Printf("Would remove %d backups\n", len(remove))
+		return nil
+	}
+
+	var failed int
+	for _, b := range remove {
+		if err := repo.Remove(b); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not remove backup", b.ID, ":", err)
+			failed++
+			continue
+		}
+		fmt.Println(b.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Could not remove %d of %d backups", failed, len(remove))
+	}
+	return nil
+}
+
+func volumeRestore(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("Missing backup ID")
+	}
+	repo, err := getBackupRepo(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := repo.Get(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+
+	arch, err := repo.Open(b)
+	if err != nil {
+		return fmt.Errorf("Could not open backup: %v", err)
+	}
+	defer arch.Close()
+
+	return importArchive(ctx, arch, ctx.Args()[1:])
+}
+
+func keygen(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("Malformed argument. Please supply the file to write the key to")
+	}
+
+	private, public, err := generateKey(ctx.String("type"))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(ctx.Args()[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not write key: %v", err)
+	}
+	_, err = fmt.Fprintln(f, private)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Could not write key: %v", err)
+	}
+
+	if public != "" {
+		fmt.Println(public)
 	}
-	docker.ContainerWait(id)
-	docker.RemoveImage(imgId, true, false)
-	docker.RemoveContainer(id, true, true)
+	return nil
 }
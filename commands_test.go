@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/cli"
+	"github.com/cpuguy83/docker-volumes/volumes"
+	"github.com/cpuguy83/docker-volumes/volumes/volumestest"
+)
+
+// fakeDocker makes the commands run against a daemon with the volume web used
+// by the running container web, and an unused volume cache
+func fakeDocker(t *testing.T) (*volumestest.Daemon, string) {
+	t.Helper()
+	d := volumestest.NewDaemon()
+	web := d.AddVolume("web", nil)
+	d.AddVolume("cache", nil)
+	id := d.AddContainer("web", map[string]string{"/data": web})
+
+	orig := connect
+	connect = func(*cli.Context, string) (volumes.Client, error) {
+		return d, nil
+	}
+	t.Cleanup(func() { connect = orig })
+	return d, id
+}
+
+// fakeStdin answers prompts with input, on a terminal if terminal is set
+func fakeStdin(t *testing.T, input string, terminal bool) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(input)
+	w.Close()
+
+	stdin, isTerminal := os.Stdin, stdinIsTerminal
+	os.Stdin = r
+	stdinIsTerminal = func() bool { return terminal }
+	t.Cleanup(func() {
+		os.Stdin, stdinIsTerminal = stdin, isTerminal
+		r.Close()
+	})
+}
+
+func rm(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var err error
+	out := captureStdout(t, func() {
+		err = volumeRm(testContext(t, append([]string{"rm"}, args...)...))
+	})
+	return out, err
+}
+
+func TestVolumeRmDryRun(t *testing.T) {
+	d, _ := fakeDocker(t)
+
+	out, err := rm(t, "--dry-run", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Would remove 1 volumes") {
+		t.Fatalf("expected the volume to remove to be listed, got %q", out)
+	}
+	if d.Volume("/var/lib/docker/volumes/cache") == nil {
+		t.Fatal("expected a dry run to not remove the volume")
+	}
+}
+
+func TestVolumeRmNotConfirmed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		terminal bool
+	}{
+		{"declined", "n\n", true},
+		{"no answer", "", true},
+		{"not a terminal", "y\n", false},
+	} {
+		d, _ := fakeDocker(t)
+		fakeStdin(t, tc.input, tc.terminal)
+
+		if _, err := rm(t, "cache"); err == nil {
+			t.Fatalf("%s: expected an error when the removal is not confirmed", tc.name)
+		}
+		if d.Volume("/var/lib/docker/volumes/cache") == nil {
+			t.Fatalf("%s: expected the volume to not be removed", tc.name)
+		}
+	}
+}
+
+func TestVolumePruneNotConfirmed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		terminal bool
+	}{
+		{"declined", "n\n", true},
+		{"no answer", "", true},
+		{"not a terminal", "y\n", false},
+	} {
+		d, _ := fakeDocker(t)
+		fakeStdin(t, tc.input, tc.terminal)
+
+		var err error
+		captureStdout(t, func() {
+			err = volumePrune(testContext(t, "prune"))
+		})
+		if err == nil {
+			t.Fatalf("%s: expected an error when the removal is not confirmed", tc.name)
+		}
+		if d.Volume("/var/lib/docker/volumes/cache") == nil {
+			t.Fatalf("%s: expected the volume to not be removed", tc.name)
+		}
+	}
+}
+
+func TestVolumeRmConfirmed(t *testing.T) {
+	d, _ := fakeDocker(t)
+	fakeStdin(t, "y\n", true)
+
+	if _, err := rm(t, "cache"); err != nil {
+		t.Fatal(err)
+	}
+	if d.Volume("/var/lib/docker/volumes/cache") != nil {
+		t.Fatal("expected the volume to be removed")
+	}
+}
+
+func TestVolumeRmYes(t *testing.T) {
+	d, _ := fakeDocker(t)
+	fakeStdin(t, "", false)
+
+	out, err := rm(t, "--yes", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Removed volume: cache") {
+		t.Fatalf("expected the volume to be reported as removed, got %q", out)
+	}
+	if d.Volume("/var/lib/docker/volumes/cache") != nil {
+		t.Fatal("expected the volume to be removed")
+	}
+}
+
+func TestVolumeRmForce(t *testing.T) {
+	d, id := fakeDocker(t)
+
+	if _, err := rm(t, "--yes", "web"); !errors.Is(err, volumes.ErrInUse) {
+		t.Fatalf("expected ErrInUse without --force, got %v", err)
+	}
+	if _, err := rm(t, "--force", "--yes", "web"); !errors.Is(err, volumes.ErrInUse) {
+		t.Fatalf("expected ErrInUse for a running container, got %v", err)
+	}
+	if d.Volume("/var/lib/docker/volumes/web") == nil {
+		t.Fatal("expected the volume of a running container to not be removed")
+	}
+
+	if err := d.StopContainer(id); err != nil {
+		t.Fatal(err)
+	}
+	out, err := rm(t, "--force", "--yes", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Removed containers: web") {
+		t.Fatalf("expected the container to be reported as removed, got %q", out)
+	}
+	if d.Volume("/var/lib/docker/volumes/web") != nil {
+		t.Fatal("expected the volume to be removed")
+	}
+	if _, err := d.FetchContainer(id); !errors.Is(err, volumes.ErrNoSuchContainer) {
+		t.Fatalf("expected the container to be removed, got %v", err)
+	}
+}
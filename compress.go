@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b, 0x08}
+	bzip2Magic = []byte{0x42, 0x5a, 0x68}
+	xzMagic    = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressStream wraps dest with a writer which compresses everything written
+// to it with the requested compression, one of gzip, zstd, xz or none.
+// The returned writer must be closed to flush the compressed stream, this does
+// not close dest.
+// Go has no native support for xz and zstd, so we shell out to the respective
+// binaries for those, both here and in decompressStream.
+func compressStream(dest io.Writer, compression string) (io.WriteCloser, error) {
+	switch strings.ToLower(compression) {
+	case "", "none":
+		return nopWriteCloser{dest}, nil
+	case "gzip", "gz":
+		return gzip.NewWriter(dest), nil
+	case "xz":
+		return cmdWriter(exec.Command("xz", "-z", "-c", "-q"), dest)
+	case "zstd", "zst":
+		return cmdWriter(exec.Command("zstd", "-c", "-q"), dest)
+	default:
+		return nil, fmt.Errorf("unsupported compression format: %s", compression)
+	}
+}
+
+// archiveExtension gets the file extension for archives with the compression
+func archiveExtension(compression string) string {
+	switch strings.ToLower(compression) {
+	case "gzip", "gz":
+		return ".tar.gz"
+	case "xz":
+		return ".tar.xz"
+	case "zstd", "zst":
+		return ".tar.zst"
+	default:
+		return ".tar"
+	}
+}
+
+// decompressStream sniffs the compression format of the stream and returns a
+// reader for the uncompressed data.
+// Uncompressed streams are passed through untouched.
+func decompressStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buf)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return ioutil.NopCloser(bzip2.NewReader(buf)), nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return cmdReader(exec.Command("xz", "-d", "-c", "-q"), buf)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return cmdReader(exec.Command("zstd", "-d", "-c", "-q"), buf)
+	default:
+		return ioutil.NopCloser(buf), nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type cmdWriteCloser struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (w *cmdWriteCloser) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v - %s", w.cmd.Path, err, strings.TrimSpace(w.stderr.String()))
+	}
+	return nil
+}
+
+// cmdWriter starts cmd with its stdout going to dest and returns a writer to
+// the command's stdin
+func cmdWriter(cmd *exec.Cmd, dest io.Writer) (io.WriteCloser, error) {
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = dest
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdWriteCloser{stdin, cmd, stderr}, nil
+}
+
+// cmdReader starts cmd with input as its stdin and returns a reader for the
+// command's stdout
+func cmdReader(cmd *exec.Cmd, input io.Reader) (io.ReadCloser, error) {
+	stderr := bytes.NewBuffer(nil)
+	pipeR, pipeW := io.Pipe()
+	cmd.Stdin = input
+	cmd.Stdout = pipeW
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			pipeW.CloseWithError(fmt.Errorf("%s: %v - %s", cmd.Path, err, strings.TrimSpace(stderr.String())))
+			return
+		}
+		pipeW.Close()
+	}()
+	return pipeR, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+// Config holds the settings from the config file, flags and their env vars
+// take precedence over it
+type Config struct {
+	HelperImage string `json:",omitempty"`
+	PullHelper  bool   `json:",omitempty"`
+}
+
+// loadConfig reads the config file, a missing file is the same as an empty one
+func loadConfig(p string) (*Config, error) {
+	var c Config
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return nil, fmt.Errorf("Could not read config file %s: %v", p, err)
+	}
+	return &c, nil
+}
+
+// configureHelper sets the helper image options from the global flags or the
+// config file
+func configureHelper(ctx *cli.Context, opts *volumes.ClientOptions) error {
+	config, err := loadConfig(ctx.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+
+	opts.HelperImage = config.HelperImage
+	if image := ctx.GlobalString("helper-image"); image != "" {
+		opts.HelperImage = image
+	}
+	opts.PullHelper = ctx.GlobalBool("pull-helper") || config.PullHelper
+	return nil
+}
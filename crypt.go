@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+// Encrypted archives start with the magic, followed by the length of the JSON
+// encoded envelope header and the header itself.
+// The archive is then encrypted in chunks with AES-256-GCM using a random key,
+// which is stored in the header wrapped with the key given by the user.
+// Each chunk's nonce is made up of a random prefix, the chunk number and a flag
+// set only on the last chunk, so chunks can't be reordered, dropped or
+// truncated without the decryption failing.
+const (
+	encryptionMagic     = "DVOLENC1"
+	encryptionCipher    = "AES-256-GCM"
+	encryptionChunkSize = 64 * 1024
+	pbkdf2Iterations    = 200000
+)
+
+var errNotEncrypted = errors.New("archive is not encrypted")
+
+type encryptionHeader struct {
+	Cipher       string
+	KeyType      string
+	KeyID        string `json:",omitempty"`
+	Salt         []byte `json:",omitempty"`
+	Iterations   int    `json:",omitempty"`
+	EphemeralKey []byte `json:",omitempty"`
+	WrapNonce    []byte
+	WrappedKey   []byte
+	NoncePrefix  []byte
+	ChunkSize    int
+}
+
+// encryptionKey is the key the user gave for encryption or decryption, only
+// one of the fields is set
+type encryptionKey struct {
+	passphrase []byte
+	key        []byte
+	recipient  *ecdh.PublicKey
+	identity   *ecdh.PrivateKey
+}
+
+func (k *encryptionKey) describe() string {
+	switch {
+	case k.passphrase != nil:
+		return "a passphrase"
+	case k.key != nil:
+		return "key " + volumes.KeyID(k.key)
+	case k.recipient != nil:
+		return "x25519 key " + volumes.KeyID(k.recipient.Bytes())
+	case k.identity != nil:
+		return "x25519 key " + volumes.KeyID(k.identity.PublicKey().Bytes())
+	}
+	return "no key"
+}
+
+// loadEncryptionKey reads a key for encryption or decryption from a key file,
+// which holds either an aes256 key or an x25519 private key
+func loadEncryptionKey(p string) (*encryptionKey, error) {
+	keyType, key, err := readKeyFile(p)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case keyTypeAES256:
+		if len(key) != 32 {
+			return nil, fmt.Errorf("aes256 keys must be 32 bytes")
+		}
+		return &encryptionKey{key: key}, nil
+	case keyTypeX25519Private:
+		identity, err := ecdh.X25519().NewPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &encryptionKey{identity: identity}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type for encryption: %s", keyType)
+	}
+}
+
+// parseRecipient parses an x25519 public key to encrypt to
+func parseRecipient(s string) (*encryptionKey, error) {
+	keyType, key, err := parseKey(s)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != keyTypeX25519Public {
+		return nil, fmt.Errorf("recipient must be an x25519 public key")
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionKey{recipient: recipient}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, b)
+	return b, err
+}
+
+// wrappingKey works out the key used to wrap the archive key, filling in the
+// header with whatever is needed to get the same key back on decryption.
+// An x25519 private key encrypts to its own public key.
+func (k *encryptionKey) wrappingKey(hdr *encryptionHeader) ([]byte, error) {
+	recipient := k.recipient
+	if recipient == nil && k.identity != nil {
+		recipient = k.identity.PublicKey()
+	}
+
+	switch {
+	case k.passphrase != nil:
+		hdr.KeyType = "passphrase"
+		salt, err := randomBytes(16)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Salt = salt
+		hdr.Iterations = pbkdf2Iterations
+		return pbkdf2.Key(sha256.New, string(k.passphrase), salt, pbkdf2Iterations, 32)
+	case k.key != nil:
+		hdr.KeyType = keyTypeAES256
+		hdr.KeyID = volumes.KeyID(k.key)
+		return k.key, nil
+	case recipient != nil:
+		hdr.KeyType = keyTypeX25519Public
+		hdr.KeyID = volumes.KeyID(recipient.Bytes())
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		hdr.EphemeralKey = ephemeral.PublicKey().Bytes()
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, err
+		}
+		return x25519WrappingKey(shared, hdr.EphemeralKey, recipient.Bytes())
+	}
+	return nil, fmt.Errorf("no encryption key given")
+}
+
+func x25519WrappingKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, "docker-volumes x25519", 32)
+}
+
+// unwrappingKey gets the key to unwrap the archive key with, based on what the
+// header says the archive was encrypted with
+func (k *encryptionKey) unwrappingKey(hdr *encryptionHeader) ([]byte, error) {
+	switch hdr.KeyType {
+	case "passphrase":
+		if k.passphrase == nil {
+			return nil, fmt.Errorf("archive is encrypted with a passphrase, but was given %s", k.describe())
+		}
+		return pbkdf2.Key(sha256.New, string(k.passphrase), hdr.Salt, hdr.Iterations, 32)
+	case keyTypeAES256:
+		if k.key == nil || volumes.KeyID(k.key) != hdr.KeyID {
+			return nil, fmt.Errorf("archive is encrypted with key %s, but was given %s", hdr.KeyID, k.describe())
+		}
+		return k.key, nil
+	case keyTypeX25519Public:
+		if k.identity == nil || volumes.KeyID(k.identity.PublicKey().Bytes()) != hdr.KeyID {
+			return nil, fmt.Errorf("archive is encrypted to x25519 key %s, but was given %s", hdr.KeyID, k.describe())
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(hdr.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("malformed encryption header: %v", err)
+		}
+		shared, err := k.identity.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		return x25519WrappingKey(shared, hdr.EphemeralKey, k.identity.PublicKey().Bytes())
+	}
+	return nil, fmt.Errorf("unsupported key type in encryption header: %s", hdr.KeyType)
+}
+
+// encryptStream wraps w with a writer which encrypts everything written to it.
+// The writer must be closed to write out the last chunk, this does not close w.
+func encryptStream(w io.Writer, key *encryptionKey) (io.WriteCloser, error) {
+	hdr := &encryptionHeader{Cipher: encryptionCipher, ChunkSize: encryptionChunkSize}
+	kek, err := key.wrappingKey(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveKey, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.NoncePrefix, err = randomBytes(7); err != nil {
+		return nil, err
+	}
+	if hdr.WrapNonce, err = randomBytes(12); err != nil {
+		return nil, err
+	}
+	wrapper, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	hdr.WrappedKey = wrapper.Seal(nil, hdr.WrapNonce, archiveKey, []byte(encryptionMagic))
+
+	hdrJson, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, len(encryptionMagic)+4)
+	copy(prefix, encryptionMagic)
+	binary.BigEndian.PutUint32(prefix[len(encryptionMagic):], uint32(len(hdrJson)))
+	if _, err := w.Write(append(prefix, hdrJson...)); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(archiveKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: hdr.NoncePrefix, chunkSize: hdr.ChunkSize}, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	prefix    []byte
+	counter   uint32
+	chunkSize int
+	buf       []byte
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	// Always hold back at least one byte so the last chunk is only ever
+	// written on Close
+	for len(e.buf) > e.chunkSize {
+		if err := e.writeChunk(e.buf[:e.chunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[e.chunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (e *encryptWriter) writeChunk(chunk []byte, last bool) error {
+	_, err := e.w.Write(e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), chunk, nil))
+	e.counter++
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	return e.writeChunk(e.buf, true)
+}
+
+// decryptStream checks if the stream is an encrypted archive and if so returns
+// a reader for the decrypted content.
+// Unencrypted streams are passed through as is, unless a key was given, in
+// which case errNotEncrypted is returned.
+func decryptStream(r io.Reader, key *encryptionKey) (io.Reader, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(len(encryptionMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, []byte(encryptionMagic)) {
+		if key != nil {
+			return nil, errNotEncrypted
+		}
+		return buf, nil
+	}
+
+	prefix := make([]byte, len(encryptionMagic)+4)
+	if _, err := io.ReadFull(buf, prefix); err != nil {
+		return nil, fmt.Errorf("malformed encryption header: %v", err)
+	}
+	hdrLen := binary.BigEndian.Uint32(prefix[len(encryptionMagic):])
+	if hdrLen > 64*1024 {
+		return nil, fmt.Errorf("malformed encryption header: too big")
+	}
+	hdrJson := make([]byte, hdrLen)
+	if _, err := io.ReadFull(buf, hdrJson); err != nil {
+		return nil, fmt.Errorf("malformed encryption header: %v", err)
+	}
+	var hdr encryptionHeader
+	if err := json.Unmarshal(hdrJson, &hdr); err != nil {
+		return nil, fmt.Errorf("malformed encryption header: %v", err)
+	}
+	if hdr.Cipher != encryptionCipher {
+		return nil, fmt.Errorf("unsupported cipher: %s", hdr.Cipher)
+	}
+	if hdr.ChunkSize <= 0 || hdr.ChunkSize > 16*1024*1024 || len(hdr.NoncePrefix) != 7 {
+		return nil, fmt.Errorf("malformed encryption header")
+	}
+
+	if key == nil {
+		if hdr.KeyID != "" {
+			return nil, fmt.Errorf("archive is encrypted with %s key %s, a key is needed to decrypt it", hdr.KeyType, hdr.KeyID)
+		}
+		return nil, fmt.Errorf("archive is encrypted with a passphrase, a passphrase is needed to decrypt it")
+	}
+
+	kek, err := key.unwrappingKey(&hdr)
+	if err != nil {
+		return nil, err
+	}
+	unwrapper, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	archiveKey, err := unwrapper.Open(nil, hdr.WrapNonce, hdr.WrappedKey, []byte(encryptionMagic))
+	if err != nil {
+		if hdr.KeyType == "passphrase" {
+			return nil, fmt.Errorf("could not decrypt archive, wrong passphrase")
+		}
+		return nil, fmt.Errorf("could not decrypt archive key, the header is corrupted")
+	}
+
+	aead, err := newGCM(archiveKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      buf,
+		aead:   aead,
+		prefix: hdr.NoncePrefix,
+		chunk:  make([]byte, hdr.ChunkSize+aead.Overhead()),
+	}, nil
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readChunk() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	var last bool
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return fmt.Errorf("encrypted archive is truncated")
+	default:
+		return err
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.prefix, d.counter, last), d.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("encrypted archive is corrupted or truncated")
+	}
+	d.counter++
+	d.buf = plain
+	d.done = last
+	return nil
+}
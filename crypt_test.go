@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+// writeKey generates a key of the type and writes its private part to a key
+// file, returning the path and the public part
+func writeKey(t *testing.T, keyType string) (string, string) {
+	t.Helper()
+	private, public, err := generateKey(keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(p, []byte(private+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p, public
+}
+
+func encryptDecrypt(t *testing.T, enc, dec *encryptionKey, data []byte) ([]byte, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := encryptStream(&buf, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := decryptStream(&buf, dec)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestEncryptWithPrivateKey(t *testing.T) {
+	keyPath, public := writeKey(t, keyTypeX25519Private)
+	identity, err := loadEncryptionKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := parseRecipient(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("volume data "), 10000)
+
+	// encrypting with the private key is the same as to its public key
+	for _, enc := range []*encryptionKey{identity, recipient} {
+		out, err := encryptDecrypt(t, enc, identity, data)
+		if err != nil {
+			t.Fatalf("encrypted with %s: %v", enc.describe(), err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("encrypted with %s: decrypted data does not match", enc.describe())
+		}
+	}
+
+	otherPath, _ := writeKey(t, keyTypeX25519Private)
+	other, err := loadEncryptionKey(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encryptDecrypt(t, identity, other, data); err == nil {
+		t.Fatal("expected decrypting with another private key to fail")
+	}
+}
+
+func TestEncryptWithSymmetricKey(t *testing.T) {
+	keyPath, _ := writeKey(t, keyTypeAES256)
+	key, err := loadEncryptionKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("volume data")
+	out, err := encryptDecrypt(t, key, key, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("decrypted data does not match")
+	}
+
+	if _, err := encryptDecrypt(t, key, &encryptionKey{passphrase: []byte("nope")}, data); err == nil {
+		t.Fatal("expected decrypting with a passphrase to fail")
+	}
+}
+
+func TestSpoolEncrypted(t *testing.T) {
+	d, _ := fakeDocker(t)
+	store, err := volumes.Load(d, volumes.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var arch bytes.Buffer
+	if err := store.Export(context.Background(), d, store.Get("web"), &arch, volumes.ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath, _ := writeKey(t, keyTypeAES256)
+	key, err := loadEncryptionKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enc bytes.Buffer
+	w, err := encryptStream(&enc, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(arch.Bytes())
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, m, err := spoolEncrypted(bytes.NewReader(enc.Bytes()), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || !bytes.Equal(out, arch.Bytes()) {
+		t.Fatal("expected the verified archive to be decrypted from the spool")
+	}
+
+	// the last chunk holds the end of the tar
+	b := enc.Bytes()
+	b[len(b)-1] ^= 1
+	if _, _, err := spoolEncrypted(bytes.NewReader(b), key, nil); err == nil {
+		t.Fatal("expected a modified archive to be refused")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+	"github.com/docker/go-units"
+	"github.com/olekukonko/tablewriter"
+)
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// formatVolumes writes out the volumes according to the requested format.
+// format is either "table", "json" or a Go template which is executed for each
+// volume.
+// sizes is optional and only used for table output
+func formatVolumes(w io.Writer, format string, vols []*volumes.Volume, sizes map[string]volumes.Size) error {
+	switch format {
+	case "", "table":
+		writeVolumeTable(w, vols, sizes)
+		return nil
+	case "json":
+		if vols == nil {
+			vols = []*volumes.Volume{}
+		}
+		return writeJSON(w, vols)
+	default:
+		return writeTemplate(w, format, vols)
+	}
+}
+
+func writeVolumeTable(w io.Writer, vols []*volumes.Volume, sizes map[string]volumes.Size) {
+	var items [][]string
+	for _, vol := range vols {
+		id := vol.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		out := []string{id, strings.Join(vol.Names, ", "), vol.HostPath}
+		if sizes != nil {
+			out = append(out, units.HumanSize(float64(sizes[vol.ID].Disk)))
+		}
+		items = append(items, out)
+	}
+
+	header := []string{"ID", "Names", "Path"}
+	if sizes != nil {
+		header = append(header, "Size")
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetBorder(false)
+	table.AppendBulk(items)
+	table.Render()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "	")
+	if err != nil {
+		return fmt.Errorf("error marshalling volume data: %v", err)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func writeTemplate(w io.Writer, format string, vols []*volumes.Volume) error {
+	tmpl, err := template.New("").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid format template: %v", err)
+	}
+	for _, v := range vols {
+		if err := tmpl.Execute(w, v); err != nil {
+			return fmt.Errorf("error executing format template: %v", err)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// bySize sorts volumes by disk usage, largest first
+type bySize struct {
+	vols  []*volumes.Volume
+	sizes map[string]volumes.Size
+}
+
+func (s bySize) Len() int      { return len(s.vols) }
+func (s bySize) Swap(i, j int) { s.vols[i], s.vols[j] = s.vols[j], s.vols[i] }
+func (s bySize) Less(i, j int) bool {
+	return s.sizes[s.vols[i].ID].Disk > s.sizes[s.vols[j].ID].Disk
+}
+
+func sortBySize(vols []*volumes.Volume, sizes map[string]volumes.Size) {
+	sort.Sort(bySize{vols, sizes})
+}
@@ -0,0 +1,11 @@
+module github.com/cpuguy83/docker-volumes
+
+go 1.24
+
+require (
+	github.com/codegangsta/cli v1.7.1-0.20150510184554-942282e931e8
+	github.com/docker/go-units v0.5.0
+	github.com/olekukonko/tablewriter v0.0.5
+)
+
+require github.com/mattn/go-runewidth v0.0.9 // indirect
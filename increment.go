@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+// loadBaseManifest reads the manifest to base an incremental export on, from
+// either a previous export archive or a manifest.json extracted from one
+func loadBaseManifest(p string, key *encryptionKey) (*volumes.Manifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in, err := decryptStream(f, key)
+	if err == errNotEncrypted {
+		// the key is for the new export, the base is fine to read as is
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		in, err = decryptStream(f, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	arch, err := decompressStream(in)
+	if err != nil {
+		return nil, err
+	}
+	defer arch.Close()
+
+	var m volumes.Manifest
+	buf := bufio.NewReader(arch)
+	if first, _ := buf.Peek(1); len(first) == 1 && first[0] == '{' {
+		if err := json.NewDecoder(buf).Decode(&m); err != nil {
+			return nil, fmt.Errorf("could not read manifest: %v", err)
+		}
+	} else {
+		found, err := volumes.FindManifest(buf)
+		if err != nil {
+			return nil, err
+		}
+		m = *found
+	}
+
+	if m.ID == "" {
+		return nil, fmt.Errorf("%s was created by an older version and cannot be used as a base", p)
+	}
+	return &m, nil
+}
+
+// verifyIncrements checks every incremental archive against its manifest, and
+// that each one is based on the one before it, returning their manifests
+func verifyIncrements(paths []string, key *encryptionKey, verifyKey ed25519.PublicKey) ([]*volumes.Manifest, error) {
+	var chain []*volumes.Manifest
+	for i, p := range paths {
+		m, err := verifyArchiveFile(p, key, verifyKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		if m.Base == "" {
+			return nil, fmt.Errorf("%s is not an incremental export", p)
+		}
+		if i > 0 && m.Base != chain[i-1].ID {
+			return nil, fmt.Errorf("%s is not based on %s", p, paths[i-1])
+		}
+		chain = append(chain, m)
+	}
+	return chain, nil
+}
+
+func verifyArchiveFile(p string, key *encryptionKey, verifyKey ed25519.PublicKey) (*volumes.Manifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in, err := decryptStream(f, key)
+	if err != nil {
+		return nil, err
+	}
+	arch, err := decompressStream(in)
+	if err != nil {
+		return nil, err
+	}
+	defer arch.Close()
+	return volumes.VerifyArchive(arch, verifyKey)
+}
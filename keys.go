@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Keys are stored as a single line of `<type>:<base64 key>`
+const (
+	keyTypeAES256         = "aes256"
+	keyTypeX25519Private  = "x25519-private"
+	keyTypeX25519Public   = "x25519"
+	keyTypeEd25519Private = "ed25519-private"
+	keyTypeEd25519Public  = "ed25519"
+)
+
+func formatKey(keyType string, key []byte) string {
+	return keyType + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func parseKey(s string) (keyType string, key []byte, err error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("malformed key, expected <type>:<base64 key>")
+	}
+	key, err = base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed key: %v", err)
+	}
+	return parts[0], key, nil
+}
+
+func readKeyFile(p string) (keyType string, key []byte, err error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read key file: %v", err)
+	}
+	return parseKey(string(b))
+}
+
+// generateKey creates a new key of the given type, returning the private key
+// and, for asymmetric keys, the public key to hand out
+func generateKey(keyType string) (private, public string, err error) {
+	switch keyType {
+	case keyTypeAES256:
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return "", "", err
+		}
+		return formatKey(keyTypeAES256, key), "", nil
+	case keyTypeX25519Public, keyTypeX25519Private:
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		return formatKey(keyTypeX25519Private, key.Bytes()), formatKey(keyTypeX25519Public, key.PublicKey().Bytes()), nil
+	case keyTypeEd25519Public, keyTypeEd25519Private:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		return formatKey(keyTypeEd25519Private, key.Seed()), formatKey(keyTypeEd25519Public, pub), nil
+	default:
+		return "", "", fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
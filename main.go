@@ -1,37 +1,38 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/sha1"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 
-	"strings"
-
 	"github.com/codegangsta/cli"
-	"github.com/cpuguy83/dockerclient"
-	"github.com/docker/docker/pkg/version"
+	"github.com/cpuguy83/docker-volumes/volumes"
 )
 
-var dockerApiVersion version.Version
+var dockerApiVersion volumes.APIVersion
 
 func main() {
+	newApp().Run(os.Args)
+}
+
+// newApp sets up the commands and flags of the app
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "docker-volumes"
 	app.Usage = "The missing volume manager for Docker"
-	app.Version = "1.2"
+	app.Version = volumes.Version
 	app.Author = "Brian Goff"
 	app.Email = "cpuguy83@gmail.com"
 	certPath := os.Getenv("DOCKER_CERT_PATH")
 	if certPath == "" {
 		certPath = filepath.Join(os.Getenv("HOME"), ".docker")
 	}
+	backupDir := filepath.Join(os.Getenv("HOME"), ".docker-volumes", "backups")
+	configPath := filepath.Join(os.Getenv("HOME"), ".docker-volumes", "config.json")
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "host, H",
@@ -67,65 +68,417 @@ func main() {
 		cli.StringFlag{
 			Name:  "docker-root",
 			Value: "/var/lib/docker",
-			Usage: "Location of the Docker root path",
+			Usage: "Location of the Docker root path, only used to find volumes on daemons older than API 1.21",
+		},
+		cli.IntFlag{
+			Name:   "parallel",
+			Value:  8,
+			Usage:  "Number of containers to inspect at a time when looking up volumes",
+			EnvVar: "DOCKER_VOLUMES_PARALLEL",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Value:  configPath,
+			Usage:  "Location of the config file",
+			EnvVar: "DOCKER_VOLUMES_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "helper-image",
+			Usage:  "Image to run helper containers from, defaults to " + volumes.DefaultHelperImage,
+			EnvVar: "DOCKER_VOLUMES_HELPER_IMAGE",
+		},
+		cli.BoolFlag{
+			Name:   "pull-helper",
+			Usage:  "Pull the helper image if it is not on the Docker host",
+			EnvVar: "DOCKER_VOLUMES_PULL_HELPER",
+		},
+		cli.StringFlag{
+			Name:   "backup-dir",
+			Value:  backupDir,
+			Usage:  "Location of the backup repository, a local path or s3://bucket/prefix",
+			EnvVar: "DOCKER_VOLUMES_BACKUP_DIR",
+		},
+		cli.StringFlag{
+			Name:   "s3-endpoint",
+			Usage:  "Endpoint for S3 compatible storage, defaults to AWS S3",
+			EnvVar: "DOCKER_VOLUMES_S3_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "s3-region",
+			Value:  "us-east-1",
+			Usage:  "Region of the S3 bucket",
+			EnvVar: "AWS_REGION",
+		},
+	}
+
+	exportFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "pause, p",
+			Usage: "Pause any container using the volume before export",
+		},
+		cli.StringFlag{
+			Name:  "compress, z",
+			Value: "none",
+			Usage: "Compress the archive: gzip, zstd, xz or none",
+		},
+		cli.BoolFlag{
+			Name:  "encrypt",
+			Usage: "Encrypt the archive, with one of --encryption-key, --recipient or --passphrase-file",
+		},
+		cli.StringFlag{
+			Name:  "encryption-key",
+			Usage: "File with the aes256 key to encrypt the archive with, or an x25519 private key to encrypt to its public key",
+		},
+		cli.StringFlag{
+			Name:  "recipient",
+			Usage: "x25519 public key to encrypt the archive to",
+		},
+		cli.StringFlag{
+			Name:   "passphrase-file",
+			Usage:  "File with the passphrase to encrypt the archive with",
+			EnvVar: "DOCKER_VOLUMES_PASSPHRASE_FILE",
+		},
+		cli.BoolFlag{
+			Name:  "numeric-owner",
+			Usage: "Only store user and group IDs, not the names from the container using the volume",
+		},
+		cli.StringFlag{
+			Name:  "xattrs-image",
+			Usage: "Also export xattrs and ACLs, read with getfattr in a helper container running this image",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "Only export changes since a previous export, given as the archive or its manifest.json",
+		},
+		cli.StringFlag{
+			Name:   "sign-key",
+			Usage:  "File with the ed25519 private key to sign the archive with",
+			EnvVar: "DOCKER_VOLUMES_SIGN_KEY",
 		},
 	}
 
+	verifyKeyFlag := cli.StringFlag{
+		Name:   "verify-key",
+		Usage:  "ed25519 public key, or a file with it, the archive must be signed with. On import the archive is held in a temp file under $TMPDIR until it is checked, still encrypted if it is",
+		EnvVar: "DOCKER_VOLUMES_VERIFY_KEY",
+	}
+
+	decryptFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "encryption-key",
+			Usage: "File with the aes256 or x25519 private key to decrypt the archive with",
+		},
+		cli.StringFlag{
+			Name:   "passphrase-file",
+			Usage:  "File with the passphrase to decrypt the archive with",
+			EnvVar: "DOCKER_VOLUMES_PASSPHRASE_FILE",
+		},
+	}
+
+	exportCmdFlags := append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "to",
+			Usage: "Upload the archive to s3://bucket/key instead of printing to stdout",
+		},
+	}, exportFlags...)
+
+	importFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "new-volume",
+			Usage: "Import into a new volume instead of an existing container, prints the new volume ID",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "Volume path to use for --new-volume, defaults to the path the volume was exported from",
+		},
+		cli.BoolFlag{
+			Name:  "skip-verify",
+			Usage: "Stream the archive straight in without checking it against its manifest, needed for archives from older versions. Otherwise the archive is held in a temp file under $TMPDIR until it is checked, uncompressed or still encrypted",
+		},
+		cli.BoolFlag{
+			Name:  "numeric-owner",
+			Usage: "Use the user and group IDs from the archive as they are, instead of looking up the names in the container",
+		},
+		cli.StringSliceFlag{
+			Name:  "map-uid",
+			Value: &cli.StringSlice{},
+			Usage: "Change the owner of files owned by a uid, as from:to, can be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "map-gid",
+			Value: &cli.StringSlice{},
+			Usage: "Change the group of files owned by a gid, as from:to, can be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "increment",
+			Value: &cli.StringSlice{},
+			Usage: "Incremental export to apply after the archive, repeat for each one in the chain, in order",
+		},
+	}
+	importFlags = append(importFlags, verifyKeyFlag)
+	importFlags = append(importFlags, decryptFlags...)
+
 	app.Commands = []cli.Command{
 		{
 			Name:    "list",
 			Aliases: []string{"ls"},
 			Usage:   "List all volumes",
-			Action:  volumeList,
+			Action:  run(volumeList),
 			Flags: []cli.Flag{
 				cli.BoolFlag{
 					Name:  "quiet, q",
 					Usage: "Display only IDs",
 				},
+				cli.BoolFlag{
+					Name:  "size, s",
+					Usage: "Display the disk usage of each volume",
+				},
+				cli.BoolFlag{
+					Name:  "sort-size",
+					Usage: "Sort by disk usage, largest first (implies --size)",
+				},
+				cli.StringSliceFlag{
+					Name:  "filter, f",
+					Value: &cli.StringSlice{},
+					Usage: "Filter output based on conditions provided (dangling, container, bind, path, name, rw)",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "table",
+					Usage: "Output format: table, json or a Go template",
+				},
+			},
+		},
+		{
+			Name:   "du",
+			Usage:  "Show disk usage of volumes",
+			Action: run(volumeDu),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "sort-size",
+					Usage: "Sort by disk usage, largest first",
+				},
+				cli.BoolFlag{
+					Name:  "bytes, b",
+					Usage: "Display sizes in bytes",
+				},
 			},
 		},
 		{
 			Name:   "inspect",
 			Usage:  "Get details of volume",
-			Action: volumeInspect,
+			Action: run(volumeInspect),
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: "Output format: json, table or a Go template",
+				},
+			},
 		},
 		{
 			Name:   "rm",
 			Usage:  "Delete a volume",
-			Action: volumeRm,
+			Action: run(volumeRm),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "Remove stopped containers using the volumes first",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "Only show what would be removed",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "Do not prompt for confirmation",
+				},
+			},
+		},
+		{
+			Name:   "prune",
+			Usage:  "Delete all volumes not in use by any container",
+			Action: run(volumePrune),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "Do not prompt for confirmation",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "Only show what would be removed",
+				},
+			},
 		},
 		{
 			Name:   "export",
 			Usage:  "Export a as a tarball. Prints to stdout",
-			Action: volumeExport,
+			Action: run(volumeExport),
+			Flags:  exportCmdFlags,
+		},
+		{
+			Name:   "import",
+			Usage:  "Import a tarball produced by the export command the specified container",
+			Action: run(volumeImport),
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "Download the archive from s3://bucket/key instead of reading from stdin",
+				},
+			}, importFlags...),
+		},
+		{
+			Name:   "verify",
+			Usage:  "Verify an archive produced by the export command against its manifest, reads from stdin if no file is given",
+			Action: run(volumeVerify),
+			Flags:  append([]cli.Flag{verifyKeyFlag}, decryptFlags...),
+		},
+		{
+			Name:   "clone",
+			Usage:  "Copy a volume into the volume of another container, on this or another Docker host",
+			Action: run(volumeClone),
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "to-host",
+					Usage: "Docker host of the destination container, defaults to --host",
+				},
 				cli.BoolFlag{
 					Name:  "pause, p",
-					Usage: "Pause any container using the volume before export",
+					Usage: "Pause any running containers using the source volume while it is copied",
 				},
 			},
 		},
 		{
-			Name:   "import",
-			Usage:  "Import a tarball produced by the export command the specified container",
-			Action: volumeImport,
+			Name:   "keygen",
+			Usage:  "Generate a key for encrypting or signing archives, prints the public key for x25519 and ed25519 keys",
+			Action: run(keygen),
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "type, t",
+					Value: "x25519",
+					Usage: "Type of key to generate: x25519 or aes256 for encryption, ed25519 for signing",
+				},
+			},
+		},
+		{
+			Name:   "backup",
+			Usage:  "Export a volume into the backup repository, prints the backup ID",
+			Action: run(volumeBackup),
+			Flags:  exportFlags,
+		},
+		{
+			Name:  "backups",
+			Usage: "Manage the backup repository",
+			Subcommands: []cli.Command{
+				{
+					Name:    "list",
+					Aliases: []string{"ls"},
+					Usage:   "List all backups",
+					Action:  run(backupList),
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "quiet, q",
+							Usage: "Display only IDs",
+						},
+					},
+				},
+				{
+					Name:   "rm",
+					Usage:  "Delete a backup",
+					Action: run(backupRm),
+				},
+				{
+					Name:   "prune",
+					Usage:  "Delete backups according to a retention policy, applied to each volume separately",
+					Action: run(backupPrune),
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "keep-last",
+							Usage: "Keep the last N backups",
+						},
+						cli.IntFlag{
+							Name:  "keep-daily",
+							Usage: "Keep the last backup of each of the last N days",
+						},
+						cli.IntFlag{
+							Name:  "keep-weekly",
+							Usage: "Keep the last backup of each of the last N weeks",
+						},
+						cli.IntFlag{
+							Name:  "keep-monthly",
+							Usage: "Keep the last backup of each of the last N months",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run, n",
+							Usage: "Only show what would be removed",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:   "restore",
+			Usage:  "Import a backup from the backup repository to the specified container",
+			Action: run(volumeRestore),
+			Flags:  importFlags,
 		},
 	}
+	return app
+}
 
-	app.Run(os.Args)
+// exitCode gets the exit status for an error from a command, so scripts can
+// tell the common failures apart
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, volumes.ErrNotFound):
+		return 2
+	case errors.Is(err, volumes.ErrInUse):
+		return 3
+	case errors.Is(err, volumes.ErrAmbiguous):
+		return 4
+	default:
+		return 1
+	}
 }
 
-func getDockerClient(ctx *cli.Context) docker.Docker {
-	docker, err := docker.NewClient(ctx.GlobalString("host"))
-	var tlsConfig tls.Config
-	tlsConfig.InsecureSkipVerify = true
+// run turns a command which returns an error into a cli action, printing the
+// error and exiting with its exit code
+func run(action func(*cli.Context) error) func(*cli.Context) {
+	return func(ctx *cli.Context) {
+		err := action(ctx)
+		if err == nil {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, volumes.ErrNoHelperImage) {
+			fmt.Fprintln(os.Stderr, "Pull it with `docker pull`, use --pull-helper, or pick another one with --helper-image")
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// connect gets the client for the Docker host, it can be swapped out to run
+// the commands against something other than a real daemon
+var connect = func(ctx *cli.Context, host string) (volumes.Client, error) {
+	return newDockerClient(ctx, host)
+}
+
+func getDockerClient(ctx *cli.Context) (volumes.Client, error) {
+	return connect(ctx, ctx.GlobalString("host"))
+}
+
+// newDockerClient connects to the daemon at host, with the TLS settings and
+// helper image from the global flags
+func newDockerClient(ctx *cli.Context, host string) (*volumes.DockerClient, error) {
+	var opts volumes.ClientOptions
 	if ctx.GlobalBool("tls") || ctx.GlobalString("tlsverify") != "" {
+		var tlsConfig tls.Config
+		tlsConfig.InsecureSkipVerify = true
 		if ctx.GlobalString("tlsverify") != "" {
 			certPool := x509.NewCertPool()
 			file, err := ioutil.ReadFile(ctx.GlobalString("tlscacert"))
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				return nil, err
 			}
 			certPool.AppendCertsFromPEM(file)
 			tlsConfig.RootCAs = certPool
@@ -137,165 +490,37 @@ func getDockerClient(ctx *cli.Context) docker.Docker {
 		if errCert == nil || errKey == nil {
 			cert, err := tls.LoadX509KeyPair(ctx.GlobalString("tlscert"), ctx.GlobalString("tlskey"))
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Couldn't load X509 key pair: %s. Key encrpyted?\n", err)
-				os.Exit(1)
+				return nil, fmt.Errorf("Couldn't load X509 key pair: %s. Key encrpyted?", err)
 			}
 			tlsConfig.Certificates = []tls.Certificate{cert}
 		}
 		tlsConfig.MinVersion = tls.VersionTLS10
-		docker.SetTlsConfig(&tlsConfig)
-	}
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-	return docker
-}
-
-func setup(client docker.Docker, rootPath string) *volStore {
-	ver, err := client.Version()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting docker daemon version")
-		os.Exit(1)
-	}
-	dockerApiVersion = version.Version(ver.ApiVersion)
-
-	var volumes = &volStore{
-		s: make(map[string]*Volume),
-	}
-	containers, err := client.FetchAllContainers(true)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "error fetching containers: %v", err)
-		os.Exit(1)
-	}
-
-	for _, c := range containers {
-		c, err = client.FetchContainer(c.Id)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "error getting container: %v", err)
-		}
-		vols, err := c.GetVolumes()
-		if err != nil {
-			fmt.Println("Error pulling volumes for:", c.Id)
-		}
-
-		for p, vol := range vols {
-			v := &Volume{Volume: *vol}
-
-			name := strings.TrimPrefix(c.Name, "/")
-			name = name + ":" + p
-
-			v.ID = v.Id()
-			if v.ID == "_data" {
-				v.ID = path.Base(path.Dir(v.HostPath))
-			}
-
-			if v.IsBindMount {
-				h := sha1.New()
-				h.Write([]byte(v.HostPath))
-				v.ID = fmt.Sprintf("%x", h.Sum(nil))
-			}
-
-			if strings.HasSuffix(v.HostPath, "_data") && dockerApiVersion.GreaterThan("1.18") && !v.IsBindMount {
-				v.HostPath = path.Dir(v.HostPath)
-			}
-			if vol, exists := volumes.s[v.ID]; exists {
-				v = vol
-			}
-
-			v.Names = append(v.Names, name)
-			v.Containers = append(v.Containers, c.Id)
-
-			volumes.Add(v)
-		}
+		opts.TLSConfig = &tlsConfig
 	}
 
-	volsPath := path.Join(rootPath, "vfs", "dir")
-	if dockerApiVersion.GreaterThanOrEqualTo("1.19") {
-		volsPath = path.Join(rootPath, "volumes")
-	}
-
-	volDirs, err := volumesFromDisk(volsPath, client)
-	if err != nil {
-		fmt.Fprint(os.Stderr, "error getting volume list: %v", err)
-		os.Exit(1)
-	}
-
-	for _, d := range volDirs {
-		hostPath := path.Join(volsPath, d)
-
-		if hostPath == volsPath || hostPath == volsPath+"/" {
-			continue
-		}
-
-		v := &Volume{Volume: docker.Volume{HostPath: hostPath, IsBindMount: false, IsReadWrite: true}}
-		vol := volumes.Find(d)
-		if vol != nil {
-			continue
-		}
-
-		volumes.Add(v)
-	}
-
-	return volumes
-}
-
-func volumesFromDisk(path string, client docker.Docker) ([]string, error) {
-	bindSpec := path + ":" + "/.docker_root"
-	containerConfig := map[string]interface{}{
-		"Image": "busybox:latest",
-		"Cmd":   []string{"/bin/sh", "-c", "ls /.docker_root/"},
-		"Volumes": map[string]struct{}{
-			"/.docker_root": struct{}{},
-		},
-		"HostConfig": map[string]interface{}{
-			"Binds": []string{bindSpec},
-		},
-	}
-
-	id, err := client.RunContainer(containerConfig)
-	defer client.RemoveContainer(id, true, true)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := client.ContainerWait(id); err != nil {
-		return nil, err
-	}
-
-	dirs, err := client.ContainerLogs(id, false, true, true, false, -1)
-	if err != nil {
+	if err := configureHelper(ctx, &opts); err != nil {
 		return nil, err
 	}
-
-	var out []string
-	var b []byte
-
-	scanner := bufio.NewScanner(dirs)
-	for scanner.Scan() {
-		b = append(b, scanner.Bytes()...)
-	}
-
-	scanner = bufio.NewScanner(bufio.NewReader(bytes.NewReader(b)))
-	scanner.Split(scanHeader)
-	for scanner.Scan() {
-		out = append(out, strings.Split(scanner.Text(), "\u0001")[0])
-	}
-	return out, nil
+	return volumes.NewClient(host, opts)
 }
 
-func scanHeader(data []byte, atEOF bool) (int, []byte, error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
+func loadOptions(ctx *cli.Context) volumes.LoadOptions {
+	return volumes.LoadOptions{
+		DockerRoot: ctx.GlobalString("docker-root"),
+		Parallel:   ctx.GlobalInt("parallel"),
 	}
+}
 
-	if i := bytes.IndexByte(data, 'A'); i >= 0 {
-		return i + 1, data[0:i], nil
-	}
+// loadVolumes finds all the volumes on the Docker host
+func loadVolumes(ctx *cli.Context, client volumes.Client) (*volumes.Store, error) {
+	return volumes.Load(client, loadOptions(ctx))
+}
 
-	if atEOF {
-		return len(data), data, nil
+func loadApiVersion(client volumes.Client) error {
+	ver, err := client.Version()
+	if err != nil {
+		return fmt.Errorf("Error getting docker daemon version: %v", err)
 	}
-
-	return 0, nil, nil
+	dockerApiVersion = volumes.APIVersion(ver.ApiVersion)
+	return nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/codegangsta/cli"
+)
+
+// testContext parses the command line the same as the app does and returns
+// the context the command's action gets, without running it.
+// Flags have to come before the args of each command.
+func testContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	app := newApp()
+	global := flag.NewFlagSet(app.Name, flag.ContinueOnError)
+	for _, f := range app.Flags {
+		f.Apply(global)
+	}
+	if err := global.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		cmd  *cli.Command
+		cmds = app.Commands
+	)
+	args = global.Args()
+	for len(args) > 0 {
+		var next *cli.Command
+		for i := range cmds {
+			if cmds[i].HasName(args[0]) {
+				next = &cmds[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		cmd, cmds, args = next, next.Subcommands, args[1:]
+	}
+	if cmd == nil {
+		t.Fatalf("no command in %v", global.Args())
+	}
+
+	set := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	for _, f := range cmd.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, set, global)
+}
+
+// captureStdout runs fn and returns everything it wrote to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	fn()
+	w.Close()
+	return string(<-out)
+}
@@ -1,4 +1,3 @@
 #!/bin/bash
-godep get
-go build -a -tags netgo -installsuffix netgo .
+CGO_ENABLED=0 go build -a -tags netgo -installsuffix netgo .
 mv docker-volumes /docker-volumes
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseIDMap parses `--map-uid`/`--map-gid` specs of the form `from:to`
+func parseIDMap(specs []string) (map[int]int, error) {
+	m := make(map[int]int)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed id mapping %q, expected from:to", spec)
+		}
+		from, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("malformed id mapping %q: %v", spec, err)
+		}
+		to, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed id mapping %q: %v", spec, err)
+		}
+		m[from] = to
+	}
+	return m, nil
+}
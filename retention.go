@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// retentionPolicy decides which backups to keep, each rule is applied on its
+// own and a backup is kept as long as any of the rules wants it
+type retentionPolicy struct {
+	// Last keeps the N most recent backups
+	Last int
+	// Daily, Weekly and Monthly keep the most recent backup of each of the last
+	// N days, weeks or months which have a backup
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+func (p retentionPolicy) Empty() bool {
+	return p.Last <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0
+}
+
+// Apply splits the backups into the ones to keep and the ones to remove.
+// The policy is applied separately for each source volume, see backupGroup.
+func (p retentionPolicy) Apply(backups []*Backup) (keep, remove []*Backup) {
+	groups := make(map[string][]*Backup)
+	for _, b := range backups {
+		g := backupGroup(b)
+		groups[g] = append(groups[g], b)
+	}
+
+	for _, group := range groups {
+		sort.Sort(byCreated(group))
+		k, r := p.applyGroup(group)
+		keep = append(keep, k...)
+		remove = append(remove, r...)
+	}
+	sort.Sort(byCreated(keep))
+	sort.Sort(byCreated(remove))
+	return keep, remove
+}
+
+// applyGroup applies the policy to backups sorted newest first
+func (p retentionPolicy) applyGroup(backups []*Backup) (keep, remove []*Backup) {
+	buckets := []struct {
+		n      int
+		bucket func(time.Time) string
+		last   string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }, ""},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}, ""},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }, ""},
+	}
+
+	for i, b := range backups {
+		keepIt := i < p.Last
+
+		created := b.Created.Local()
+		for j := range buckets {
+			rule := &buckets[j]
+			if rule.n <= 0 {
+				continue
+			}
+			if key := rule.bucket(created); key != rule.last {
+				rule.last = key
+				rule.n--
+				keepIt = true
+			}
+		}
+
+		if keepIt {
+			keep = append(keep, b)
+		} else {
+			remove = append(remove, b)
+		}
+	}
+	return keep, remove
+}
+
+// backupGroup gets the name of the source volume backups are grouped by for
+// retention.
+// Volumes can be used by several containers, so the first name when sorted is
+// used to get the same group no matter what order the names are in.
+// Volumes not used by any container have no names and are grouped by ID.
+func backupGroup(b *Backup) string {
+	if len(b.Names) == 0 {
+		return b.VolumeID
+	}
+	names := append([]string(nil), b.Names...)
+	sort.Strings(names)
+	return names[0]
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	at := func(s string) time.Time {
+		created, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+
+	for _, tc := range []struct {
+		name    string
+		policy  retentionPolicy
+		backups []string
+		keep    []string
+	}{
+		{
+			name:    "last",
+			policy:  retentionPolicy{Last: 2},
+			backups: []string{"2024-01-01 10:00", "2024-01-01 11:00", "2024-01-01 12:00", "2024-01-01 09:00"},
+			keep:    []string{"2024-01-01 12:00", "2024-01-01 11:00"},
+		},
+		{
+			name:    "last more than there are",
+			policy:  retentionPolicy{Last: 5},
+			backups: []string{"2024-01-01 10:00", "2024-01-02 10:00"},
+			keep:    []string{"2024-01-02 10:00", "2024-01-01 10:00"},
+		},
+		{
+			name:    "daily across midnight",
+			policy:  retentionPolicy{Daily: 2},
+			backups: []string{"2024-01-03 10:00", "2024-01-03 08:00", "2024-01-02 23:59", "2024-01-02 00:01", "2024-01-01 12:00"},
+			keep:    []string{"2024-01-03 10:00", "2024-01-02 23:59"},
+		},
+		{
+			name:   "daily skips days without backups",
+			policy: retentionPolicy{Daily: 2},
+			// there is nothing on the 9th, so the 8th is the second day
+			backups: []string{"2024-01-10 10:00", "2024-01-08 10:00", "2024-01-07 10:00"},
+			keep:    []string{"2024-01-10 10:00", "2024-01-08 10:00"},
+		},
+		{
+			name:   "weekly across monday",
+			policy: retentionPolicy{Weekly: 2},
+			// 2024-01-08 is a monday, the 1st to 7th is one ISO week
+			backups: []string{"2024-01-08 00:30", "2024-01-07 23:00", "2024-01-06 10:00", "2024-01-01 10:00", "2023-12-31 10:00"},
+			keep:    []string{"2024-01-08 00:30", "2024-01-07 23:00"},
+		},
+		{
+			name:   "weekly across the new year",
+			policy: retentionPolicy{Weekly: 3},
+			// 2020-12-28 to 2021-01-03 is week 53 of 2020
+			backups: []string{"2021-01-04 10:00", "2021-01-03 10:00", "2020-12-31 10:00", "2020-12-28 10:00", "2020-12-27 10:00"},
+			keep:    []string{"2021-01-04 10:00", "2021-01-03 10:00", "2020-12-27 10:00"},
+		},
+		{
+			name:    "monthly across month ends",
+			policy:  retentionPolicy{Monthly: 2},
+			backups: []string{"2024-03-01 00:00", "2024-02-29 23:59", "2024-02-01 10:00", "2024-01-31 10:00"},
+			keep:    []string{"2024-03-01 00:00", "2024-02-29 23:59"},
+		},
+		{
+			name:   "mixed",
+			policy: retentionPolicy{Last: 1, Daily: 2, Weekly: 3, Monthly: 3},
+			backups: []string{
+				"2024-03-04 12:00", // last, daily, weekly and monthly
+				"2024-03-04 06:00",
+				"2024-03-03 18:00", // daily and weekly
+				"2024-03-01 09:00",
+				"2024-02-26 09:00", // monthly
+				"2024-02-25 09:00", // weekly
+				"2024-02-10 09:00",
+				"2024-01-15 09:00", // monthly
+				"2023-12-31 09:00",
+			},
+			keep: []string{"2024-03-04 12:00", "2024-03-03 18:00", "2024-02-26 09:00", "2024-02-25 09:00", "2024-01-15 09:00"},
+		},
+	} {
+		var backups []*Backup
+		for _, c := range tc.backups {
+			backups = append(backups, &Backup{ID: c, VolumeID: "vol", Created: at(c).UTC()})
+		}
+
+		keep, remove := tc.policy.Apply(backups)
+		var kept []string
+		for _, b := range keep {
+			kept = append(kept, b.ID)
+		}
+		if strings.Join(kept, ", ") != strings.Join(tc.keep, ", ") {
+			t.Errorf("%s: expected to keep %v, kept %v", tc.name, tc.keep, kept)
+		}
+		if len(keep)+len(remove) != len(backups) {
+			t.Errorf("%s: expected %d backups to be kept or removed, got %d", tc.name, len(backups), len(keep)+len(remove))
+		}
+	}
+}
+
+func TestRetentionPolicyPerVolume(t *testing.T) {
+	now := time.Now().UTC()
+	backups := []*Backup{
+		{ID: "web-new", VolumeID: "1", Names: []string{"web:/data", "app:/data"}, Created: now},
+		{ID: "web-old", VolumeID: "1", Names: []string{"app:/data", "web:/data"}, Created: now.Add(-time.Hour)},
+		{ID: "db-old", VolumeID: "2", Names: []string{"db:/var/lib/db"}, Created: now.Add(-2 * time.Hour)},
+		{ID: "unused", VolumeID: "3", Created: now.Add(-3 * time.Hour)},
+	}
+
+	keep, remove := retentionPolicy{Last: 1}.Apply(backups)
+	if len(keep) != 3 || len(remove) != 1 || remove[0].ID != "web-old" {
+		t.Fatalf("expected only web-old to be removed, got %d kept and %v", len(keep), remove)
+	}
+}
+
+func TestBackupPruneDryRun(t *testing.T) {
+	dir := t.TempDir()
+	storage := localStorage(dir)
+	for i := 0; i < 3; i++ {
+		b := &Backup{
+			ID:       volumes.GenerateRandomID(),
+			VolumeID: "vol",
+			Created:  time.Now().UTC().Add(-time.Duration(i) * 24 * time.Hour),
+		}
+		meta, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Write(b.ID, backupMetaName, bytes.NewReader(meta)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() int {
+		backups, err := (&backupRepo{storage}).List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(backups)
+	}
+
+	var err error
+	out := captureStdout(t, func() {
+		err = backupPrune(testContext(t, "--backup-dir", dir, "backups", "prune", "--keep-last", "1", "--dry-run"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Would remove 2 backups") {
+		t.Fatalf("expected the backups to remove to be listed, got %q", out)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("expected a dry run to keep all 3 backups, %d left", n)
+	}
+
+	captureStdout(t, func() {
+		err = backupPrune(testContext(t, "--backup-dir", dir, "backups", "prune", "--keep-last", "1"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected 1 backup to be kept, %d left", n)
+	}
+}
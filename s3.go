@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the size of each part in multipart uploads, this is also how
+// much of an upload is held in memory at any time.
+// S3 allows up to 10000 parts, so this allows for uploads of ~320GB
+var s3PartSize = 32 * 1024 * 1024
+
+const s3MaxParts = 10000
+
+// s3Client is a minimal client for S3 compatible object storage, supporting
+// just what's needed to store and fetch archives.
+// Requests are signed with AWS signature v4.
+type s3Client struct {
+	endpoint     *url.URL
+	pathStyle    bool
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Client sets up an S3 client.
+// Credentials are taken from the standard AWS environment variables, falling
+// back to the shared credentials file.
+// If no endpoint is given AWS S3 is used, otherwise requests go to the
+// endpoint with path style addressing, as MinIO and most other S3 compatible
+// stores expect.
+func newS3Client(endpoint, region string) (*s3Client, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	c := &s3Client{region: region, client: http.DefaultClient}
+
+	if endpoint == "" {
+		c.endpoint = &url.URL{Scheme: "https", Host: "s3." + region + ".amazonaws.com"}
+	} else {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+		}
+		c.endpoint = u
+		c.pathStyle = true
+	}
+
+	c.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	c.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	c.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	if c.accessKey == "" || c.secretKey == "" {
+		if err := c.loadSharedCredentials(); err != nil {
+			return nil, err
+		}
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, fmt.Errorf("no S3 credentials found, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or add them to ~/.aws/credentials")
+	}
+	return c, nil
+}
+
+// loadSharedCredentials reads credentials for the profile set in AWS_PROFILE,
+// or the default profile, from the shared credentials file
+func (c *s3Client) loadSharedCredentials() error {
+	credsPath := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credsPath == "" {
+		credsPath = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(credsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("could not read S3 credentials: %v", err)
+	}
+	defer f.Close()
+
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			c.accessKey = value
+		case "aws_secret_access_key":
+			c.secretKey = value
+		case "aws_session_token":
+			c.sessionToken = value
+		}
+	}
+	return scanner.Err()
+}
+
+// parseS3URL splits an s3://bucket/key URL into its bucket and key
+func parseS3URL(s string) (bucket, key string, err error) {
+	if !strings.HasPrefix(s, "s3://") {
+		return "", "", fmt.Errorf("not an S3 URL: %s", s)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s, "s3://"), "/", 2)
+	if parts[0] == "" {
+		return "", "", fmt.Errorf("missing bucket in S3 URL: %s", s)
+	}
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	return parts[0], key, nil
+}
+
+func isS3URL(s string) bool {
+	return strings.HasPrefix(s, "s3://")
+}
+
+func (c *s3Client) objectURL(bucket, key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawQuery = s3EncodeQuery(query)
+	return &u
+}
+
+func (c *s3Client) do(method, bucket, key string, query url.Values, body io.Reader, headers map[string]string) (*http.Response, error) {
+	u := c.objectURL(bucket, key, query)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// keep the path exactly as it was signed
+	req.URL.Opaque = "//" + u.Host + s3EncodePath(u.Path)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	c.sign(req, u, time.Now().UTC())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		msg, _ := ioutil.ReadAll(resp.Body)
+		if xml.Unmarshal(msg, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("S3 %s %s/%s: %s: %s", method, bucket, key, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("S3 %s %s/%s: %s", method, bucket, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS signature v4 to the request.
+// Payloads are not signed so uploads can be streamed, unless the request
+// already has the SHA-256 of its payload in X-Amz-Content-Sha256.
+func (c *s3Client) sign(req *http.Request, u *url.URL, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	req.Header.Set("Host", u.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	var names []string
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "host" || lk == "content-type" || lk == "content-md5" || strings.HasPrefix(lk, "x-amz-") {
+			names = append(names, lk)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EncodePath(u.Path),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	h := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape encodes everything but the unreserved characters, as required for
+// signing
+func s3Escape(s string, keepSlash bool) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			buf.WriteByte(b)
+		case b == '/' && keepSlash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+func s3EncodePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return s3Escape(p, true)
+}
+
+func s3EncodeQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Upload streams r into the object at key, returning the number of bytes
+// uploaded.
+// Anything bigger than a single part is uploaded with a multipart upload so
+// only one part is ever held in memory.
+func (c *s3Client) Upload(bucket, key string, r io.Reader, meta map[string]string) (int64, error) {
+	headers := make(map[string]string)
+	for k, v := range meta {
+		headers["X-Amz-Meta-"+k] = v
+	}
+
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := c.do("PUT", bucket, key, nil, bytes.NewReader(buf[:n]), headers)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.do("POST", bucket, key, url.Values{"uploads": {""}}, nil, headers)
+	if err != nil {
+		return 0, err
+	}
+	var initResult struct {
+		UploadId string
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("could not start multipart upload: %v", err)
+	}
+
+	total, err := c.uploadParts(bucket, key, initResult.UploadId, buf[:n], r)
+	if err != nil {
+		if resp, abortErr := c.do("DELETE", bucket, key, url.Values{"uploadId": {initResult.UploadId}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return 0, err
+	}
+	return total, nil
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (c *s3Client) uploadParts(bucket, key, uploadId string, first []byte, r io.Reader) (int64, error) {
+	var (
+		parts []s3Part
+		total int64
+		buf   = first
+	)
+	for num := 1; ; num++ {
+		if num > s3MaxParts {
+			return 0, fmt.Errorf("upload is too big, it would need more than %d parts", s3MaxParts)
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadId}}
+		resp, err := c.do("PUT", bucket, key, query, bytes.NewReader(buf), nil)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		parts = append(parts, s3Part{PartNumber: num, ETag: resp.Header.Get("ETag")})
+		total += int64(len(buf))
+
+		buf = first[:cap(first)]
+		n, err := io.ReadFull(r, buf)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		buf = buf[:n]
+	}
+
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do("POST", bucket, key, url.Values{"uploadId": {uploadId}}, bytes.NewReader(body), map[string]string{"Content-Type": "application/xml"})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// S3 can report errors completing the upload with a 200 status
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		return 0, fmt.Errorf("could not complete multipart upload: %s: %s", result.Code, result.Message)
+	}
+	return total, nil
+}
+
+// Download gets a reader for the object at key
+func (c *s3Client) Download(bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *s3Client) Delete(bucket, key string) error {
+	resp, err := c.do("DELETE", bucket, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List gets the keys of all objects under the prefix.
+// When a delimiter is given the common prefixes, ie. "dirs", are returned
+// instead.
+func (c *s3Client) List(bucket, prefix, delimiter string) ([]string, error) {
+	var (
+		out   []string
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do("GET", bucket, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key string
+			}
+			CommonPrefixes []struct {
+				Prefix string
+			}
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read S3 listing: %v", err)
+		}
+
+		if delimiter != "" {
+			for _, p := range result.CommonPrefixes {
+				out = append(out, p.Prefix)
+			}
+		} else {
+			for _, o := range result.Contents {
+				out = append(out, o.Key)
+			}
+		}
+
+		if !result.IsTruncated {
+			return out, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
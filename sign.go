@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+)
+
+// loadSigningKey reads an ed25519 private key made with `keygen --type ed25519`
+func loadSigningKey(p string) (ed25519.PrivateKey, error) {
+	keyType, key, err := readKeyFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != keyTypeEd25519Private || len(key) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", p)
+	}
+	return ed25519.NewKeyFromSeed(key), nil
+}
+
+// loadVerifyKey gets an ed25519 public key, either given directly or in a file
+func loadVerifyKey(s string) (ed25519.PublicKey, error) {
+	var (
+		keyType string
+		key     []byte
+		err     error
+	)
+	if strings.HasPrefix(s, keyTypeEd25519Public+":") {
+		keyType, key, err = parseKey(s)
+	} else {
+		keyType, key, err = readKeyFile(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if keyType != keyTypeEd25519Public || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", s)
+	}
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,187 @@
+package volumes
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type apiClient struct {
+	scheme string
+	host   string
+	client *http.Client
+}
+
+func newAPIClient(host string, tlsConfig *tls.Config) (*apiClient, error) {
+	proto, addr := "unix", host
+	if parts := strings.SplitN(host, "://", 2); len(parts) == 2 {
+		proto, addr = parts[0], parts[1]
+	}
+
+	c := &apiClient{scheme: "http", host: addr}
+	switch proto {
+	case "unix":
+		// The host is not used for unix sockets, but it needs to be valid
+		c.host = "docker"
+	case "tcp":
+		proto = "tcp"
+		if tlsConfig != nil {
+			c.scheme = "https"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol for docker host: %s", proto)
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial(proto, addr)
+			},
+		},
+	}
+	return c, nil
+}
+
+func (c *apiClient) do(method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := url.URL{Scheme: c.scheme, Host: c.host, Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &apiError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("error from daemon (%d): %s", e.StatusCode, e.Message)
+}
+
+// GetArchive streams a tar archive of the path in the container, including
+// any volumes mounted at or under that path
+func (c *DockerClient) GetArchive(id, path string) (io.ReadCloser, error) {
+	resp, err := c.api.do("GET", "/containers/"+id+"/archive", url.Values{"path": {path}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PutArchive extracts the tar archive into the path in the container, which
+// must be a dir that already exists
+func (c *DockerClient) PutArchive(id, path string, archive io.Reader) error {
+	resp, err := c.api.do("PUT", "/containers/"+id+"/archive", url.Values{"path": {path}}, archive, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// APIVolume is a volume as returned by the volume list endpoint
+type APIVolume struct {
+	Name       string
+	Driver     string
+	Mountpoint string
+}
+
+// ListVolumes lists all the volumes known to the daemon, which includes ones
+// no container uses. Needs API 1.21 or newer.
+func (c *DockerClient) ListVolumes() ([]APIVolume, error) {
+	resp, err := c.api.do("GET", "/volumes", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Volumes []APIVolume
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("could not read volume list: %v", err)
+	}
+	return list.Volumes, nil
+}
+
+// RemoveVolume removes the volume and its data. Needs API 1.21 or newer.
+func (c *DockerClient) RemoveVolume(name string) error {
+	resp, err := c.api.do("DELETE", "/volumes/"+name, nil, nil, "")
+	if err != nil {
+		if e, ok := err.(*apiError); ok {
+			switch e.StatusCode {
+			case 404:
+				return fmt.Errorf("%w: %s", ErrNotFound, name)
+			case 409:
+				return fmt.Errorf("%w: %s", ErrInUse, e.Message)
+			}
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *DockerClient) ImageExists(name string) (bool, error) {
+	resp, err := c.api.do("GET", "/images/"+name+"/json", nil, nil, "")
+	if err != nil {
+		if e, ok := err.(*apiError); ok && e.StatusCode == 404 {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// PullImage pulls the image from its registry, only anonymous pulls are
+// supported
+func (c *DockerClient) PullImage(name string) error {
+	repo, tag := name, "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		repo, tag = name[:i], name[i+1:]
+	}
+	resp, err := c.api.do("POST", "/images/create", url.Values{"fromImage": {repo}, "tag": {tag}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// errors during the pull only show up in the progress stream
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifestName is the name of the manifest in export archives, it is always
+// the last entry in the archive since the digests are calculated as the
+// archive is streamed out
+const manifestName = "manifest.json"
+
+// ErrNoManifest is returned when verifying an archive without a manifest
+var ErrNoManifest = errors.New("archive has no manifest, it is either truncated or was created by an older version")
+
+// Manifest lists every entry of an export archive, along with the SHA-256
+// digest of every file, so the archive can be checked before it is imported
+type Manifest struct {
+	// ID identifies the export, incremental exports refer to their base by it
+	ID    string `json:",omitempty"`
+	Base  string `json:",omitempty"`
+	Files []ManifestEntry
+	// Unchanged lists the files an incremental export left out since they are
+	// the same as in the base
+	Unchanged []ManifestEntry `json:",omitempty"`
+}
+
+// ManifestEntry is an entry of the Manifest
+type ManifestEntry struct {
+	Path     string
+	Type     string
+	Size     int64
+	Mode     int64
+	Uid      int
+	Gid      int
+	Linkname string `json:",omitempty"`
+	SHA256   string `json:",omitempty"`
+	// Xattrs is a digest of the entry's xattrs
+	Xattrs string `json:",omitempty"`
+}
+
+func newManifestEntry(hdr *tar.Header) ManifestEntry {
+	entry := ManifestEntry{
+		Path:   hdr.Name,
+		Size:   hdr.Size,
+		Mode:   hdr.Mode,
+		Uid:    hdr.Uid,
+		Gid:    hdr.Gid,
+		Xattrs: xattrsDigest(hdr),
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		entry.Type = "file"
+	case tar.TypeDir:
+		entry.Type = "dir"
+	case tar.TypeSymlink:
+		entry.Type = "symlink"
+		entry.Linkname = hdr.Linkname
+	case tar.TypeLink:
+		entry.Type = "link"
+		entry.Linkname = hdr.Linkname
+	default:
+		entry.Type = fmt.Sprintf("other(%c)", hdr.Typeflag)
+	}
+	return entry
+}
+
+// archiveWriter writes a tar archive, recording every entry in a manifest
+// which gets added to the end of the archive on Close, followed by its
+// signature if a signing key is set
+type archiveWriter struct {
+	tw       *tar.Writer
+	manifest Manifest
+	signKey  ed25519.PrivateKey
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{
+		tw:       tar.NewWriter(w),
+		manifest: Manifest{ID: GenerateRandomID()},
+	}
+}
+
+func (a *archiveWriter) WriteEntry(hdr *tar.Header, content io.Reader) error {
+	// a manifest from an archive being copied is stale, a new one is written on Close
+	if hdr.Name == manifestName || hdr.Name == signatureName {
+		return nil
+	}
+
+	entry := newManifestEntry(hdr)
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if entry.Type == "file" {
+		h := sha256.New()
+		if _, err := io.Copy(a.tw, io.TeeReader(content, h)); err != nil {
+			return err
+		}
+		entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	a.manifest.Files = append(a.manifest.Files, entry)
+	return nil
+}
+
+// WriteFile adds a regular file with the given content to the archive
+func (a *archiveWriter) WriteFile(name string, content []byte) error {
+	return a.WriteEntry(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}, bytes.NewReader(content))
+}
+
+// CopyFrom copies all the entries from a tar stream into the archive
+func (a *archiveWriter) CopyFrom(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read archive: %s", err)
+		}
+		if err := a.WriteEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *archiveWriter) Close() error {
+	m, err := json.MarshalIndent(a.manifest, "", "	")
+	if err != nil {
+		return err
+	}
+	if err := a.writeTrailer(manifestName, m); err != nil {
+		return err
+	}
+	if a.signKey != nil {
+		sig, err := signManifest(a.signKey, m)
+		if err != nil {
+			return err
+		}
+		if err := a.writeTrailer(signatureName, sig); err != nil {
+			return err
+		}
+	}
+	return a.tw.Close()
+}
+
+// writeTrailer writes an entry which is not recorded in the manifest
+func (a *archiveWriter) writeTrailer(name string, content []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(content)
+	return err
+}
+
+// VerifyArchive reads through an export archive and checks every entry against
+// the archive's manifest, returning the manifest once everything checks out.
+// If a key is passed the archive must also carry a valid signature by it.
+// Archives with more than one entry for the same path are refused.
+func VerifyArchive(r io.Reader, key ed25519.PublicKey) (*Manifest, error) {
+	var (
+		tr          = tar.NewReader(r)
+		seen        = make(map[string]ManifestEntry)
+		names       = make(map[string]bool)
+		manifest    *Manifest
+		manifestRaw []byte
+		sig         *Signature
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("archive is corrupted or truncated: %v", err)
+		}
+		if manifest != nil {
+			if hdr.Name != signatureName || sig != nil {
+				return nil, fmt.Errorf("unexpected entry after manifest: %s", hdr.Name)
+			}
+			sig = &Signature{}
+			if err := json.NewDecoder(tr).Decode(sig); err != nil {
+				return nil, fmt.Errorf("could not read signature: %v", err)
+			}
+			continue
+		}
+
+		if hdr.Name == manifestName {
+			if manifestRaw, err = ioutil.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("archive is corrupted or truncated, reading manifest: %v", err)
+			}
+			manifest = &Manifest{}
+			if err := json.Unmarshal(manifestRaw, manifest); err != nil {
+				return nil, fmt.Errorf("could not read manifest: %v", err)
+			}
+			continue
+		}
+
+		// a later entry with the same path replaces the earlier one when
+		// extracting, eg. a file written through a symlink of the same name,
+		// so only one of them could ever match the manifest
+		name := path.Clean(importEntryName(hdr.Name))
+		if names[name] {
+			return nil, fmt.Errorf("duplicate entry in archive: %s", hdr.Name)
+		}
+		names[name] = true
+
+		entry := newManifestEntry(hdr)
+		if entry.Type == "file" {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, fmt.Errorf("archive is corrupted or truncated, reading %s: %v", hdr.Name, err)
+			}
+			entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+		}
+		seen[entry.Path] = entry
+	}
+
+	if manifest == nil {
+		return nil, ErrNoManifest
+	}
+	if key != nil {
+		if err := verifyManifestSignature(key, manifestRaw, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, expected := range manifest.Files {
+		actual, exists := seen[expected.Path]
+		if !exists {
+			return nil, fmt.Errorf("file missing from archive: %s", expected.Path)
+		}
+		if actual != expected {
+			return nil, fmt.Errorf("file does not match manifest: %s", expected.Path)
+		}
+		delete(seen, expected.Path)
+	}
+	for p := range seen {
+		return nil, fmt.Errorf("file in archive not listed in manifest: %s", p)
+	}
+
+	return manifest, nil
+}
+
+// VerifyingReader passes through everything read from r while verifying it as
+// an export archive on the side.
+// The result of the verification is sent on the returned channel once r has
+// been read to the end.
+func VerifyingReader(r io.Reader) (io.Reader, <-chan VerifyResult) {
+	pr, pw := io.Pipe()
+	resCh := make(chan VerifyResult, 1)
+	go func() {
+		m, err := VerifyArchive(pr, nil)
+		// keep draining so reads from r don't block on a failed verification
+		io.Copy(ioutil.Discard, pr)
+		resCh <- VerifyResult{m, err}
+	}()
+	return &teeReader{r, pw}, resCh
+}
+
+// VerifyResult is the outcome of the verification done by VerifyingReader
+type VerifyResult struct {
+	Manifest *Manifest
+	Err      error
+}
+
+type teeReader struct {
+	r  io.Reader
+	pw *io.PipeWriter
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.pw.Write(p[:n])
+	}
+	if err == io.EOF {
+		t.pw.Close()
+	} else if err != nil {
+		t.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+// SpoolVerified copies the archive to a temp file, checking it against its
+// manifest and signature on the way.
+// The file is only returned if the archive passed, positioned at the start.
+// It is already unlinked, so closing it is all that is needed to clean up.
+func SpoolVerified(r io.Reader, key ed25519.PublicKey) (*os.File, *Manifest, error) {
+	f, err := ioutil.TempFile("", "docker-volumes-import")
+	if err != nil {
+		return nil, nil, err
+	}
+	os.Remove(f.Name())
+
+	m, err := VerifyArchive(io.TeeReader(r, f), key)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, m, nil
+}
+
+// FindManifest reads through an export archive to get to its manifest
+func FindManifest(r io.Reader) (*Manifest, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrNoManifest
+		}
+		if err != nil {
+			return nil, fmt.Errorf("archive is corrupted or truncated: %v", err)
+		}
+		if hdr.Name == manifestName {
+			var m Manifest
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return nil, fmt.Errorf("could not read manifest: %v", err)
+			}
+			return &m, nil
+		}
+	}
+}
+
+// copyTarFile reads through the tar archive to the file name and copies its
+// content to w
+func copyTarFile(r io.Reader, name string, w io.Writer) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s not found in archive", name)
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimPrefix(path.Clean(hdr.Name), "/") == name {
+			_, err := io.Copy(w, tr)
+			return err
+		}
+	}
+}
+
+// GenerateRandomID returns an unique id
+func GenerateRandomID() string {
+	for {
+		id := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, id); err != nil {
+			panic(err) // This shouldn't happen
+		}
+		value := hex.EncodeToString(id)
+		// if we try to parse the truncated for as an int and we don't have
+		// an error then the value is all numberic and causes issues when
+		// used as a hostname. ref #3869
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			continue
+		}
+		return value
+	}
+}
@@ -0,0 +1,89 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"strings"
+	"testing"
+)
+
+// signedArchive writes a signed export archive with the file data/x
+func signedArchive(t *testing.T, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	a := newArchiveWriter(&b)
+	a.signKey = key
+	if err := a.WriteEntry(&tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteFile("data/x", []byte("legit")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// insertBefore copies the archive, adding hdr right before the entry named
+// before. Everything else, including the manifest and its signature, is kept
+// as is.
+func insertBefore(t *testing.T, arch []byte, before string, hdr *tar.Header) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(arch))
+	tw := tar.NewWriter(&b)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name == before {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestVerifyArchiveDuplicatePath(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := signedArchive(t, key)
+	if _, err := VerifyArchive(bytes.NewReader(arch), pub); err != nil {
+		t.Fatalf("expected the untouched archive to pass: %v", err)
+	}
+
+	for _, name := range []string{"data/x", "./data/x", "data//x"} {
+		// the symlink would be replaced by the file when extracting, but
+		// anything writing through it before then ends up outside the volume
+		evil := insertBefore(t, arch, "data/x", &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeSymlink,
+			Linkname: "/etc/shadow",
+		})
+		for _, key := range []ed25519.PublicKey{nil, pub} {
+			_, err := VerifyArchive(bytes.NewReader(evil), key)
+			if err == nil || !strings.Contains(err.Error(), "duplicate entry") {
+				t.Fatalf("%s, key %v: expected a duplicate entry error, got %v", name, key != nil, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package volumes
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+)
+
+// DefaultHelperImage is the image helper containers are run from unless
+// another one is set in the ClientOptions
+const DefaultHelperImage = "busybox:latest"
+
+// Client is the part of the Docker remote API used to manage volumes.
+// It is implemented by DockerClient for a real daemon, and by the in-memory
+// daemon in volumestest.
+type Client interface {
+	Version() (*DaemonVersion, error)
+
+	FetchAllContainers(all bool) ([]*Container, error)
+	// FetchContainer inspects the container, the error wraps
+	// ErrNoSuchContainer if there is none
+	FetchContainer(name string) (*Container, error)
+	// ContainerVolumes gets the volumes of a container from FetchContainer,
+	// keyed by the path they are mounted at
+	ContainerVolumes(c *Container) (map[string]*Mount, error)
+	ListVolumes() ([]APIVolume, error)
+	// RemoveVolume removes the volume through the daemon, needs API 1.21 or
+	// newer
+	RemoveVolume(name string) error
+
+	// HelperImage is the image to run helper containers from, it needs a
+	// shell and the usual busybox tools
+	HelperImage() string
+	RunContainer(config map[string]interface{}) (string, error)
+	RemoveContainer(id string, force, volumes bool) error
+	ContainerWait(id string) error
+	ContainerLogs(id string, follow, stdout, stderr, timestamps bool, tail int) (io.Reader, error)
+	ContainerPause(id string) error
+	ContainerUnpause(id string) error
+
+	GetArchive(id, path string) (io.ReadCloser, error)
+	PutArchive(id, path string, archive io.Reader) error
+
+	// used with daemons older than API 1.20 only
+	Copy(id, path string) (io.Reader, error)
+	Build(context io.Reader, name string, quiet, rm bool) (io.Reader, error)
+	DecodeStream(r io.Reader) []string
+	RemoveImage(id string, force, noprune bool) error
+}
+
+// ClientOptions are the optional settings for NewClient
+type ClientOptions struct {
+	// TLSConfig is used for tcp hosts if set
+	TLSConfig *tls.Config
+	// HelperImage defaults to DefaultHelperImage
+	HelperImage string
+	// PullHelper pulls the helper image if it is not on the Docker host,
+	// otherwise running a helper fails with ErrNoHelperImage
+	PullHelper bool
+}
+
+// DockerClient is a Client for a Docker daemon, talking to its remote API
+type DockerClient struct {
+	api *apiClient
+
+	helperImage string
+	pullHelper  bool
+	helperReady bool
+}
+
+// NewClient connects to the daemon at host, a unix:// or tcp:// URL
+func NewClient(host string, opts ClientOptions) (*DockerClient, error) {
+	api, err := newAPIClient(host, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	c := &DockerClient{api: api, helperImage: opts.HelperImage, pullHelper: opts.PullHelper}
+	if c.helperImage == "" {
+		c.helperImage = DefaultHelperImage
+	}
+	return c, nil
+}
+
+func (c *DockerClient) HelperImage() string {
+	return c.helperImage
+}
+
+// RunContainer makes sure the helper image is there before the first helper
+// container is run, so a missing image gets a clear error instead of one from
+// deep down in some command
+func (c *DockerClient) RunContainer(config map[string]interface{}) (string, error) {
+	if image, _ := config["Image"].(string); image == c.helperImage && !c.helperReady {
+		if err := c.ensureImage(image, c.pullHelper); err != nil {
+			return "", err
+		}
+		c.helperReady = true
+	}
+	return c.runContainer(config)
+}
+
+// ensureImage checks that the image is on the Docker host, pulling it if
+// asked to
+func (c *DockerClient) ensureImage(image string, pull bool) error {
+	exists, err := c.ImageExists(image)
+	if err != nil {
+		return fmt.Errorf("could not check for helper image %s: %v", image, err)
+	}
+	if exists {
+		return nil
+	}
+	if !pull {
+		return fmt.Errorf("%w: %s", ErrNoHelperImage, image)
+	}
+	if err := c.PullImage(image); err != nil {
+		return fmt.Errorf("could not pull helper image %s: %v", image, err)
+	}
+	return nil
+}
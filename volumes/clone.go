@@ -0,0 +1,86 @@
+package volumes
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Clone streams the content of the volume at srcPath on the src daemon
+// straight into the volume at dstPath on the dst daemon, with a helper
+// container on each side. Both need Docker API 1.20 or newer.
+// Nothing is stored in between, the archive from one helper is passed on to
+// the other as it is read.
+func Clone(src Client, srcPath string, dst Client, dstPath string) error {
+	data, err := getVolumeArchive(src, srcPath)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(rebaseVolumeArchive(w, data))
+	}()
+
+	err = putVolumeArchive(dst, dstPath, r)
+	r.CloseWithError(err)
+	return err
+}
+
+// rebaseVolumeArchive rewrites a tar stream from getVolumeArchive to be
+// relative to the volume, so it can be extracted right into another one
+func rebaseVolumeArchive(w io.Writer, data io.Reader) error {
+	tr := tar.NewReader(data)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read volume archive: %s", err)
+		}
+
+		hdr.Name = strings.TrimPrefix(rebaseExportPath(hdr.Name), "data/")
+		if hdr.Name == "" {
+			hdr.Name = "./"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strings.TrimPrefix(rebaseExportPath(hdr.Linkname), "data/")
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// putVolumeArchive extracts a tar stream into the volume at hostPath, through
+// a helper container which has it mounted
+func putVolumeArchive(docker Client, hostPath string, archive io.Reader) error {
+	containerConfig := map[string]interface{}{
+		"Image": docker.HelperImage(),
+		"Cmd":   []string{"/bin/sh", "-c", "true"},
+		"Volumes": map[string]struct{}{
+			"/.dockervolume": struct{}{},
+		},
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{hostPath + ":/.dockervolume"},
+		},
+	}
+
+	containerId, err := docker.RunContainer(containerConfig)
+	defer docker.RemoveContainer(containerId, true, true)
+	if err != nil {
+		return fmt.Errorf("%s - %s", containerId, err)
+	}
+	if err := docker.ContainerWait(containerId); err != nil {
+		return err
+	}
+	return docker.PutArchive(containerId, "/.dockervolume", archive)
+}
@@ -0,0 +1,218 @@
+package volumes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// LoadOptions are the optional settings for Load
+type LoadOptions struct {
+	// DockerRoot is where the daemon keeps its data, only needed to find
+	// volumes on daemons older than API 1.21. Defaults to /var/lib/docker.
+	DockerRoot string
+	// Parallel is the number of containers to inspect at a time, defaults to 1
+	Parallel int
+}
+
+// Load finds all the volumes on the Docker host, along with the containers
+// using them.
+// If any container could not be inspected no volumes are returned, as the
+// volumes it uses would look like they are not used by anything. Containers
+// which are removed while the volumes are loaded are left out.
+// Only volumes of the local driver are loaded, the data of other drivers is
+// not in a dir on the host which helper containers could work with.
+func Load(client Client, opts LoadOptions) (*Store, error) {
+	return load(context.Background(), client, opts)
+}
+
+func load(ctx context.Context, client Client, opts LoadOptions) (*Store, error) {
+	ver, err := client.Version()
+	if err != nil {
+		return nil, fmt.Errorf("error getting docker daemon version: %v", err)
+	}
+	if opts.DockerRoot == "" {
+		opts.DockerRoot = "/var/lib/docker"
+	}
+
+	volumes := NewStore(APIVersion(ver.ApiVersion))
+	containers, err := client.FetchAllContainers(true)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching containers: %v", err)
+	}
+
+	inspected, errs := inspectContainers(ctx, client, containers, opts.Parallel)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("error inspecting %d of %d containers:\n%w", len(errs), len(containers), errors.Join(errs...))
+	}
+
+	for _, c := range inspected {
+		vols, _ := client.ContainerVolumes(c)
+		for p, vol := range vols {
+			v := NewVolumeFromDocker(vol)
+
+			name := strings.TrimPrefix(c.Name, "/")
+			name = name + ":" + p
+
+			if vol, exists := volumes.s[v.ID]; exists {
+				v = vol
+			}
+
+			v.Names = append(v.Names, name)
+			v.Containers = append(v.Containers, c.Id)
+
+			volumes.Add(v)
+		}
+	}
+
+	var found []*Volume
+	if volumes.APIVersion.GreaterThanOrEqualTo("1.21") {
+		found, err = volumesFromAPI(client)
+	} else {
+		found, err = volumesFromDisk(client, volumes.APIVersion, opts.DockerRoot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting volume list: %w", err)
+	}
+
+	for _, v := range found {
+		if volumes.Get(v.ID) != nil {
+			continue
+		}
+		volumes.Add(v)
+	}
+
+	return volumes, nil
+}
+
+// inspectContainers fetches the details of all the containers with up to
+// parallel requests at a time, the results are in the same order as the
+// containers. Containers which no longer exist are skipped, errors for all
+// the others which failed are returned.
+// Once ctx is cancelled the remaining containers are skipped.
+func inspectContainers(ctx context.Context, client Client, containers []*Container, parallel int) ([]*Container, []error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var (
+		results = make([]*Container, len(containers))
+		errs    = make([]error, len(containers))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for n := 0; n < parallel; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				c, err := client.FetchContainer(containers[i].Id)
+				if errors.Is(err, ErrNoSuchContainer) {
+					continue
+				}
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: %v", containers[i].Id, err)
+					continue
+				}
+				if _, err := client.ContainerVolumes(c); errors.Is(err, ErrNoSuchContainer) {
+					continue
+				} else if err != nil {
+					errs[i] = fmt.Errorf("%s: could not get volumes: %v", c.Id, err)
+					continue
+				}
+				results[i] = c
+			}
+		}()
+	}
+	for i := range containers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var (
+		inspected []*Container
+		failed    []error
+	)
+	for i := range containers {
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			continue
+		}
+		if results[i] != nil {
+			inspected = append(inspected, results[i])
+		}
+	}
+	return inspected, failed
+}
+
+// volumesFromAPI gets all volumes from the daemon's volume list, so the ones
+// not used by any container are found as well. Volumes of other drivers than
+// local, and any without a mount point, are skipped.
+func volumesFromAPI(client Client) ([]*Volume, error) {
+	list, err := client.ListVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	var vols []*Volume
+	for _, vol := range list {
+		if vol.Driver != "local" || vol.Mountpoint == "" {
+			continue
+		}
+		hostPath := vol.Mountpoint
+		if path.Base(hostPath) == "_data" {
+			hostPath = path.Dir(hostPath)
+		}
+		vols = append(vols, &Volume{
+			Mount: Mount{HostPath: hostPath, IsBindMount: false, IsReadWrite: true},
+			ID:    vol.Name,
+		})
+	}
+	return vols, nil
+}
+
+// volumesFromDisk is for daemons without the volume list API, it lists the
+// volume dirs under the docker root with a helper container
+func volumesFromDisk(client Client, apiVersion APIVersion, rootPath string) ([]*Volume, error) {
+	volsPath := path.Join(rootPath, "vfs", "dir")
+	if apiVersion.GreaterThanOrEqualTo("1.19") {
+		volsPath = path.Join(rootPath, "volumes")
+	}
+
+	containerConfig := map[string]interface{}{
+		"Image": client.HelperImage(),
+		"Cmd":   []string{"/bin/sh", "-c", "ls -1 /.docker_root/"},
+		"Volumes": map[string]struct{}{
+			"/.docker_root": struct{}{},
+		},
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{volsPath + ":/.docker_root"},
+		},
+	}
+	out, err := RunHelper(client, containerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var vols []*Volume
+	for _, d := range strings.Split(string(out), "\n") {
+		if d == "" {
+			continue
+		}
+		vols = append(vols, &Volume{
+			Mount: Mount{HostPath: path.Join(volsPath, d), IsBindMount: false, IsReadWrite: true},
+			ID:    d,
+		})
+	}
+	return vols, nil
+}
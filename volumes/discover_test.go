@@ -0,0 +1,93 @@
+package volumes_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+	"github.com/cpuguy83/docker-volumes/volumes/volumestest"
+)
+
+// racingDaemon removes the container gone right after the containers are
+// listed, and fails to inspect the container broken
+type racingDaemon struct {
+	*volumestest.Daemon
+	gone, broken string
+}
+
+func (d *racingDaemon) FetchAllContainers(all bool) ([]*volumes.Container, error) {
+	containers, err := d.Daemon.FetchAllContainers(all)
+	if err != nil {
+		return nil, err
+	}
+	if d.gone != "" {
+		if err := d.RemoveContainer(d.gone, true, false); err != nil {
+			return nil, err
+		}
+	}
+	return containers, nil
+}
+
+func (d *racingDaemon) FetchContainer(name string) (*volumes.Container, error) {
+	if name == d.broken {
+		return nil, errors.New("connection reset")
+	}
+	return d.Daemon.FetchContainer(name)
+}
+
+func TestLoadContainerRemoved(t *testing.T) {
+	d, id := newDaemon(t)
+	gone := d.AddContainer("gone", map[string]string{"/cache": "/var/lib/docker/volumes/cache"})
+
+	store, err := volumes.Load(&racingDaemon{Daemon: d, gone: gone}, volumes.LoadOptions{Parallel: 2})
+	if err != nil {
+		t.Fatalf("expected a removed container to be skipped, got %v", err)
+	}
+	if web := store.Get("web"); !equal(web.Containers, []string{id}) {
+		t.Fatalf("expected web to still be used by %s, got %v", id, web.Containers)
+	}
+	if cache := store.Get("cache"); cache == nil || len(cache.Containers) != 0 {
+		t.Fatal("expected cache to be listed as unused once its container is gone")
+	}
+}
+
+func TestLoadContainerFailed(t *testing.T) {
+	d, id := newDaemon(t)
+
+	_, err := volumes.Load(&racingDaemon{Daemon: d, broken: id}, volumes.LoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("expected the failed inspect to fail the load, got %v", err)
+	}
+}
+
+// pluginDaemon also lists a volume of another driver and one without a mount
+// point
+type pluginDaemon struct {
+	*volumestest.Daemon
+}
+
+func (d *pluginDaemon) ListVolumes() ([]volumes.APIVolume, error) {
+	list, err := d.Daemon.ListVolumes()
+	return append(list,
+		volumes.APIVolume{Name: "remote", Driver: "rexray", Mountpoint: "/var/lib/rexray/volumes/remote"},
+		volumes.APIVolume{Name: "unmounted", Driver: "local"},
+	), err
+}
+
+func TestLoadLocalOnly(t *testing.T) {
+	d, _ := newDaemon(t)
+
+	store, err := volumes.Load(&pluginDaemon{d}, volumes.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"remote", "unmounted"} {
+		if store.Get(id) != nil {
+			t.Fatalf("expected %s to be skipped", id)
+		}
+	}
+	if store.Get("cache") == nil {
+		t.Fatal("expected the local volumes to be loaded")
+	}
+}
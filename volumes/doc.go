@@ -0,0 +1,28 @@
+// Package volumes finds and manages the volumes of a Docker host through the
+// remote API, without needing access to the host's filesystem.
+// Anything that needs to touch volume data is done by short lived helper
+// containers which have the volumes bind-mounted.
+//
+// Manager is the simplest way in:
+//
+//	client, err := volumes.NewClient("unix:///var/run/docker.sock", volumes.ClientOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	m := volumes.NewManager(client, volumes.LoadOptions{})
+//	dangling, err := m.List(ctx, volumes.ListOptions{
+//		Filters: volumes.Filters{"dangling": {"true"}},
+//	})
+//
+// Export archives are tar streams with the volume data under `data/`, a
+// config.json with the Volume and a manifest of every entry, which is checked
+// by VerifyArchive and OpenImport. Compressing or encrypting them is up to the
+// caller.
+//
+// Errors for missing, ambiguous and in use volumes wrap ErrNotFound,
+// ErrAmbiguous and ErrInUse, check for them with errors.Is.
+package volumes
+
+// Version is the version of the package, it follows semantic versioning and
+// is the same as the version of the docker-volumes command
+const Version = "1.3.0"
@@ -0,0 +1,308 @@
+package volumes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DaemonVersion is the version info of a Docker daemon
+type DaemonVersion struct {
+	Version    string
+	ApiVersion string
+}
+
+// ContainerState is the state of a container
+type ContainerState struct {
+	Running  bool
+	Paused   bool
+	ExitCode int
+	Error    string
+}
+
+// Container is a container as returned by the inspect endpoint. Containers
+// from FetchAllContainers only have the Id, Name and Image set.
+type Container struct {
+	Id    string
+	Name  string
+	Image string
+	State ContainerState
+
+	// MountPoints are the volumes and bind-mounts of the container with API
+	// 1.20 or newer
+	MountPoints []MountPoint `json:"Mounts"`
+	// Volumes has the host path of the volume at each path in the container
+	// before API 1.20, VolumesRW whether it is writable
+	Volumes    map[string]string
+	VolumesRW  map[string]bool
+	HostConfig struct {
+		Binds []string
+	}
+}
+
+// MountPoint is a volume or bind-mount of a container with API 1.20 or newer
+type MountPoint struct {
+	Name        string
+	Source      string
+	Destination string
+	Driver      string
+	RW          bool
+}
+
+// Mount is a volume as mounted into a container
+type Mount struct {
+	HostPath    string
+	VolPath     string
+	IsReadWrite bool
+	IsBindMount bool
+}
+
+// Id gets the ID of the volume from its host path
+func (m *Mount) Id() string {
+	return path.Base(m.HostPath)
+}
+
+func (c *DockerClient) Version() (*DaemonVersion, error) {
+	var ver DaemonVersion
+	if err := c.getJSON("/version", nil, &ver); err != nil {
+		return nil, err
+	}
+	return &ver, nil
+}
+
+// FetchAllContainers lists the containers, including stopped ones if all is
+// set
+func (c *DockerClient) FetchAllContainers(all bool) ([]*Container, error) {
+	var list []struct {
+		Id    string
+		Names []string
+		Image string
+	}
+	if err := c.getJSON("/containers/json", url.Values{"all": {strconv.FormatBool(all)}}, &list); err != nil {
+		return nil, err
+	}
+
+	containers := make([]*Container, 0, len(list))
+	for _, l := range list {
+		container := &Container{Id: l.Id, Image: l.Image}
+		if len(l.Names) > 0 {
+			container.Name = l.Names[0]
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// FetchContainer inspects the container with the name or ID, the error wraps
+// ErrNoSuchContainer if there is none
+func (c *DockerClient) FetchContainer(name string) (*Container, error) {
+	var container Container
+	if err := c.getJSON("/containers/"+name+"/json", nil, &container); err != nil {
+		if e, ok := err.(*apiError); ok && e.StatusCode == 404 {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchContainer, name)
+		}
+		return nil, err
+	}
+	return &container, nil
+}
+
+// ContainerVolumes gets the volumes of the container, keyed by the path they
+// are mounted at
+func (c *DockerClient) ContainerVolumes(container *Container) (map[string]*Mount, error) {
+	vols := make(map[string]*Mount)
+	if container.MountPoints != nil {
+		for _, m := range container.MountPoints {
+			if m.Name != "" && m.Driver != "" && m.Driver != "local" {
+				// the data of other volume drivers is not on the host
+				continue
+			}
+			vols[m.Destination] = &Mount{
+				HostPath:    m.Source,
+				VolPath:     m.Destination,
+				IsReadWrite: m.RW,
+				// only volumes have a name
+				IsBindMount: m.Name == "",
+			}
+		}
+		return vols, nil
+	}
+
+	// Before API 1.20 bind-mounts are only told apart by the binds they were
+	// created with
+	binds := make(map[string]bool)
+	for _, b := range container.HostConfig.Binds {
+		if parts := strings.Split(b, ":"); len(parts) > 1 {
+			binds[parts[1]] = true
+		}
+	}
+	for volPath, hostPath := range container.Volumes {
+		vols[volPath] = &Mount{
+			HostPath:    hostPath,
+			VolPath:     volPath,
+			IsReadWrite: container.VolumesRW[volPath],
+			IsBindMount: binds[volPath],
+		}
+	}
+	return vols, nil
+}
+
+// runContainer creates and starts a container. The ID is returned even if the
+// container could not be started, so it can be removed.
+func (c *DockerClient) runContainer(config map[string]interface{}) (string, error) {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.api.do("POST", "/containers/create", nil, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		Id string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("could not read created container: %v", err)
+	}
+	return created.Id, c.post("/containers/"+created.Id+"/start", nil)
+}
+
+func (c *DockerClient) RemoveContainer(id string, force, volumes bool) error {
+	resp, err := c.api.do("DELETE", "/containers/"+id, url.Values{
+		"force": {strconv.FormatBool(force)},
+		"v":     {strconv.FormatBool(volumes)},
+	}, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ContainerWait blocks until the container exits
+func (c *DockerClient) ContainerWait(id string) error {
+	return c.post("/containers/"+id+"/wait", nil)
+}
+
+// ContainerLogs gets the output of the container, stdout and stderr are
+// multiplexed the same as for attach. A negative tail gets all of it.
+func (c *DockerClient) ContainerLogs(id string, follow, stdout, stderr, timestamps bool, tail int) (io.Reader, error) {
+	lines := "all"
+	if tail >= 0 {
+		lines = strconv.Itoa(tail)
+	}
+	resp, err := c.api.do("GET", "/containers/"+id+"/logs", url.Values{
+		"follow":     {strconv.FormatBool(follow)},
+		"stdout":     {strconv.FormatBool(stdout)},
+		"stderr":     {strconv.FormatBool(stderr)},
+		"timestamps": {strconv.FormatBool(timestamps)},
+		"tail":       {lines},
+	}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *DockerClient) ContainerPause(id string) error {
+	return c.post("/containers/"+id+"/pause", nil)
+}
+
+func (c *DockerClient) ContainerUnpause(id string) error {
+	return c.post("/containers/"+id+"/unpause", nil)
+}
+
+// Copy gets a tar archive of the path in the container, the archive endpoints
+// replace it from API 1.20 on
+func (c *DockerClient) Copy(id, path string) (io.Reader, error) {
+	body, err := json.Marshal(map[string]string{"Resource": path})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.api.do("POST", "/containers/"+id+"/copy", nil, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Build builds an image tagged name from the tar context, the build output is
+// returned as a stream of JSON messages for DecodeStream
+func (c *DockerClient) Build(context io.Reader, name string, quiet, rm bool) (io.Reader, error) {
+	resp, err := c.api.do("POST", "/build", url.Values{
+		"t":  {name},
+		"q":  {strconv.FormatBool(quiet)},
+		"rm": {strconv.FormatBool(rm)},
+	}, context, "application/tar")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// DecodeStream reads the JSON messages of a build or pull and returns their
+// non-empty output lines. An error message ends the stream and is the last
+// line.
+func (c *DockerClient) DecodeStream(r io.Reader) []string {
+	var out []string
+	dec := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			break
+		}
+		if msg.Error != "" {
+			out = append(out, msg.Error)
+			break
+		}
+		if s := strings.TrimSpace(msg.Stream); s != "" {
+			out = append(out, s)
+		}
+	}
+	if closer, ok := r.(io.Closer); ok {
+		closer.Close()
+	}
+	return out
+}
+
+func (c *DockerClient) RemoveImage(id string, force, noprune bool) error {
+	resp, err := c.api.do("DELETE", "/images/"+id, url.Values{
+		"force":   {strconv.FormatBool(force)},
+		"noprune": {strconv.FormatBool(noprune)},
+	}, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *DockerClient) getJSON(path string, query url.Values, v interface{}) error {
+	resp, err := c.api.do("GET", path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// post sends a request without a body and discards the response
+func (c *DockerClient) post(path string, query url.Values) error {
+	resp, err := c.api.do("POST", path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
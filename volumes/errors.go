@@ -0,0 +1,40 @@
+package volumes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when no volume matches the ID or name
+	ErrNotFound = errors.New("no such volume")
+	// ErrInUse is returned when removing a volume a container still uses
+	ErrInUse = errors.New("volume is in use")
+	// ErrNoSuchContainer is returned when inspecting a container which does
+	// not exist, e.g. one removed since the containers were listed
+	ErrNoSuchContainer = errors.New("no such container")
+	// ErrAmbiguous is matched by an AmbiguousError
+	ErrAmbiguous = errors.New("more than one volume matches")
+	// ErrNoHelperImage is returned when the helper image is not on the Docker
+	// host and is not to be pulled
+	ErrNoHelperImage = errors.New("helper image is not on the Docker host")
+)
+
+// AmbiguousError is returned when an ID prefix or name matches more than one
+// volume, it is never resolved to any of them
+type AmbiguousError struct {
+	// ID is the ID prefix or name which was looked up
+	ID string
+	// Candidates are the IDs of all the volumes it matches
+	Candidates []string
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("%s matches more than one volume: %s", e.ID, strings.Join(e.Candidates, ", "))
+}
+
+// Is makes errors.Is(err, ErrAmbiguous) true for an AmbiguousError
+func (e *AmbiguousError) Is(target error) bool {
+	return target == ErrAmbiguous
+}
@@ -0,0 +1,342 @@
+package volumes
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// PauseContainers pauses the containers, eg. the ones using a volume while it
+// is copied. Containers which could not be paused are left running.
+func PauseContainers(docker Client, containers []string) {
+	for _, c := range containers {
+		err := docker.ContainerPause(c)
+		if err != nil {
+			docker.ContainerUnpause(c)
+			continue
+		}
+	}
+}
+
+// UnpauseContainers undoes PauseContainers
+func UnpauseContainers(docker Client, containers []string) {
+	for _, c := range containers {
+		docker.ContainerUnpause(c)
+	}
+}
+
+// ExportOptions are the optional settings for exports
+type ExportOptions struct {
+	// Pause pauses the containers using the volume while it is exported
+	Pause bool
+	// SignKey signs the archive
+	SignKey ed25519.PrivateKey
+	// Base makes the export incremental, holding only changes since the
+	// export the manifest is from. Needs Docker API 1.20 or newer.
+	Base *Manifest
+	// NumericOwner only stores user and group IDs, otherwise the names are
+	// looked up in the first container using the volume
+	NumericOwner bool
+	// XattrsImage is set to also export xattrs and ACLs, they are read by a
+	// helper container running this image, which needs getfattr. Needs Docker
+	// API 1.20 or newer.
+	XattrsImage string
+}
+
+// Export writes an export archive of the volume to w.
+// The archive is uncompressed, it holds a Dockerfile and config.json for
+// importing it by building an image, the volume data under `data/` and a
+// manifest of everything in it, see VerifyArchive.
+// Cancelling ctx stops the export, w is left with a partial archive.
+func (s *Store) Export(ctx context.Context, client Client, v *Volume, w io.Writer, opts ExportOptions) error {
+	o := exportOptions{signKey: opts.SignKey, base: opts.Base}
+	if !opts.NumericOwner {
+		o.owners = exportOwnerNames(client, v)
+	}
+	if opts.XattrsImage != "" {
+		var err error
+		if o.xattrs, err = volumeXattrs(client, opts.XattrsImage, v.HostPath); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.Pause {
+		PauseContainers(client, v.Containers)
+		defer UnpauseContainers(client, v.Containers)
+	}
+
+	arch, err := copyForExport(client, s.APIVersion, v, o)
+	if err != nil {
+		return fmt.Errorf("could not create export archive: %w", err)
+	}
+	defer arch.Close()
+	if err := copyContext(ctx, w, arch); err != nil {
+		return fmt.Errorf("could not write export archive: %w", err)
+	}
+	return nil
+}
+
+// copyContext copies r to w, closing r to stop the copy if ctx is cancelled
+func copyContext(ctx context.Context, w io.Writer, r io.ReadCloser) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-done:
+		}
+	}()
+
+	_, err := io.Copy(w, r)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// ExportDockerfile is put in export archives, building an image from the
+// archive and running it with the volume at /.dockervolume imports the data.
+// This is only used to import into daemons older than API 1.20.
+var ExportDockerfile = `
+FROM %s
+ADD data /.volData
+ADD config.json /.volData/config.json
+CMD rm /.volData/config.json && cp -a /.volData/. /.dockervolume/
+`
+
+// copyForExport streams an export archive of the volume.
+// The volume is bind-mounted into a helper container and its content is pulled
+// through the archive API, rewritten on the fly to go under `data/` next to the
+// Dockerfile and config.json, so nothing is buffered on either side.
+// Daemons older than API 1.20 don't have the archive API and fall back to
+// building the archive in the helper container.
+func copyForExport(docker Client, apiVersion APIVersion, v *Volume, opts exportOptions) (io.ReadCloser, error) {
+	if apiVersion.LessThan("1.20") {
+		if opts.base != nil {
+			return nil, fmt.Errorf("incremental exports need Docker API 1.20 or newer")
+		}
+		if opts.xattrs != nil {
+			return nil, fmt.Errorf("exporting xattrs needs Docker API 1.20 or newer")
+		}
+		arch, err := copyForExportLegacy(docker, v)
+		if err != nil {
+			return nil, err
+		}
+		r, w := io.Pipe()
+		go func() {
+			aw := newArchiveWriter(w)
+			aw.signKey = opts.signKey
+			err := aw.CopyFrom(arch)
+			arch.Close()
+			if err == nil {
+				err = aw.Close()
+			}
+			w.CloseWithError(err)
+		}()
+		return r, nil
+	}
+
+	vJson, err := json.MarshalIndent(v, "", "	")
+	if err != nil {
+		return nil, fmt.Errorf("Could not export volume data")
+	}
+
+	data, err := getVolumeArchive(docker, v.HostPath)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.helperImage = docker.HelperImage()
+	r, w := io.Pipe()
+	go func() {
+		err := writeExportArchive(w, data, vJson, opts)
+		data.Close()
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+// getVolumeArchive streams a tar archive of the volume at hostPath, through a
+// helper container which has it mounted read-only.
+// The top level dir in the archive is the mount point in the helper, rather
+// than anything to do with the volume. The helper is removed on Close.
+func getVolumeArchive(docker Client, hostPath string) (io.ReadCloser, error) {
+	containerConfig := map[string]interface{}{
+		"Image": docker.HelperImage(),
+		"Cmd":   []string{"/bin/sh", "-c", "true"},
+		"Volumes": map[string]struct{}{
+			"/.dockervolume": struct{}{},
+		},
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{hostPath + ":/.dockervolume:ro"},
+		},
+	}
+
+	containerId, err := docker.RunContainer(containerConfig)
+	if err != nil {
+		docker.RemoveContainer(containerId, true, true)
+		return nil, fmt.Errorf("%s - %s", containerId, err)
+	}
+	if err := docker.ContainerWait(containerId); err != nil {
+		docker.RemoveContainer(containerId, true, true)
+		return nil, fmt.Errorf("Could not get archive: %s", err)
+	}
+
+	data, err := docker.GetArchive(containerId, "/.dockervolume")
+	if err != nil {
+		docker.RemoveContainer(containerId, true, true)
+		return nil, fmt.Errorf("Could not get archive: %s", err)
+	}
+	return &helperArchive{data, docker, containerId}, nil
+}
+
+type helperArchive struct {
+	io.ReadCloser
+	docker      Client
+	containerId string
+}
+
+func (a *helperArchive) Close() error {
+	err := a.ReadCloser.Close()
+	a.docker.RemoveContainer(a.containerId, true, true)
+	return err
+}
+
+// exportOptions holds the optional parts of an export
+type exportOptions struct {
+	// signKey signs the archive
+	signKey ed25519.PrivateKey
+	// base makes the export incremental, holding only changes since the
+	// export the manifest is from
+	base *Manifest
+	// owners gives the user and group names to store, none are stored if nil
+	owners *ownerNames
+	// xattrs of the volume's files, keyed by path relative to the volume
+	xattrs map[string]map[string]string
+	// helperImage is what the Dockerfile for legacy imports is based on
+	helperImage string
+}
+
+// annotate sets the owner names and xattrs of an entry rebased under `data/`
+func (o exportOptions) annotate(hdr *tar.Header) {
+	o.owners.apply(hdr)
+	setXattrs(hdr, o.xattrs[xattrPath(strings.TrimPrefix(strings.TrimPrefix(hdr.Name, "data"), "/"))])
+}
+
+// writeExportArchive writes out the export archive, made up of the Dockerfile
+// used for import, the volume config, the volume data from the passed in tar
+// stream and finally the manifest and its signature
+func writeExportArchive(w io.Writer, data io.Reader, config []byte, opts exportOptions) error {
+	aw := newArchiveWriter(w)
+	aw.signKey = opts.signKey
+	if opts.base != nil {
+		return writeIncrementalArchive(aw, data, config, opts)
+	}
+	if err := aw.WriteFile("Dockerfile", []byte(fmt.Sprintf(ExportDockerfile, opts.helperImage))); err != nil {
+		return err
+	}
+	if err := aw.WriteFile("config.json", config); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read volume archive: %s", err)
+		}
+
+		hdr.Name = rebaseExportPath(hdr.Name)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = rebaseExportPath(hdr.Linkname)
+		}
+		opts.annotate(hdr)
+		if err := aw.WriteEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+
+	return aw.Close()
+}
+
+// rebaseExportPath swaps out the top level dir of paths in archives from the
+// daemon, which is named after the path being archived, for `data`
+func rebaseExportPath(name string) string {
+	parts := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		return "data/"
+	}
+	return "data/" + parts[1]
+}
+
+func copyForExportLegacy(docker Client, v *Volume) (io.ReadCloser, error) {
+	bindSpec := v.HostPath + ":/.dockervolume"
+
+	vJson, err := json.MarshalIndent(v, "", "	")
+	if err != nil {
+		return nil, fmt.Errorf("Could not export volume data")
+	}
+	jsonStr := string(vJson)
+
+	// Since we're using busybox's tar, it does not support appending files
+	// Instead we'll handle adding in Dockerfile/config.json manually
+	cmd := fmt.Sprintf(
+		"mkdir -p /volumeData && cp -a /.dockervolume /volumeData/data && echo '%s' > /volumeData/Dockerfile && echo '%s' > /volumeData/config.json; cd /volumeData && tar -cf volume.tar .",
+		fmt.Sprintf(ExportDockerfile, docker.HelperImage()),
+		jsonStr,
+	)
+	containerConfig := map[string]interface{}{
+		"Image": docker.HelperImage(),
+		"Cmd":   []string{"/bin/sh", "-c", cmd},
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{bindSpec},
+		},
+	}
+
+	containerId, err := docker.RunContainer(containerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%s - %s", containerId, err)
+	}
+	defer docker.RemoveContainer(containerId, true, true)
+
+	// Wait for the container to exit, signaling that our archive is ready
+	if err := docker.ContainerWait(containerId); err != nil {
+		return nil, fmt.Errorf("Could not get archive: %s", err)
+	}
+
+	// This is a tar of a tar, we only want the inner tar, so do some more stuff
+	tmpArch, err := docker.Copy(containerId, "/volumeData/volume.tar")
+	if err != nil {
+		return nil, fmt.Errorf("Could not get archive: %s", err)
+	}
+
+	// spool the inner-tar to a temp file, the container is gone once this
+	// returns
+	f, err := ioutil.TempFile("", "docker-volumes-")
+	if err != nil {
+		return nil, fmt.Errorf("Could not create temp file: %s", err)
+	}
+	os.Remove(f.Name())
+	if err := copyTarFile(tmpArch, "volume.tar", f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Could not untar archive: %s", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,68 @@
+package volumes_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+func TestExportImport(t *testing.T) {
+	d, _ := newDaemon(t)
+	d.AddVolume("empty", nil)
+	store := load(t, d)
+
+	src := d.Volume("/var/lib/docker/volumes/web")
+	src.Files["css/site.css"] = []byte("body {}")
+
+	var arch bytes.Buffer
+	if err := store.Export(context.Background(), d, store.Get("web"), &arch, volumes.ExportOptions{NumericOwner: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	imp, err := volumes.OpenImport(bytes.NewReader(arch.Bytes()), volumes.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+	if imp.Manifest == nil {
+		t.Fatal("expected the archive to be verified against its manifest")
+	}
+	if imp.Config == nil || imp.Config.VolPath != "/data" {
+		t.Fatalf("expected the config of the volume at /data, got %+v", imp.Config)
+	}
+
+	if err := imp.Extract(d, store.Get("empty").HostPath); err != nil {
+		t.Fatal(err)
+	}
+	dst := d.Volume("/var/lib/docker/volumes/empty")
+	if len(dst.Files) != len(src.Files) {
+		t.Fatalf("expected %d files, got %d", len(src.Files), len(dst.Files))
+	}
+	for name, data := range src.Files {
+		if !bytes.Equal(dst.Files[name], data) {
+			t.Fatalf("%s: expected %q, got %q", name, data, dst.Files[name])
+		}
+	}
+}
+
+func TestImportCorrupted(t *testing.T) {
+	d, _ := newDaemon(t)
+	store := load(t, d)
+
+	var arch bytes.Buffer
+	if err := store.Export(context.Background(), d, store.Get("web"), &arch, volumes.ExportOptions{NumericOwner: true}); err != nil {
+		t.Fatal(err)
+	}
+	b := arch.Bytes()
+	i := bytes.Index(b, []byte("hello"))
+	if i < 0 {
+		t.Fatal("expected the file content in the archive")
+	}
+	b[i] = 'j'
+
+	if _, err := volumes.OpenImport(bytes.NewReader(b), volumes.ImportOptions{}); err == nil {
+		t.Fatal("expected a modified archive to be refused")
+	}
+}
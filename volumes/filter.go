@@ -0,0 +1,131 @@
+package volumes
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Filters holds the values passed in for each filter key.
+// Values for the same key are OR'd together, different keys are AND'd.
+// The keys are:
+//
+//	dangling   true for volumes not used by any container
+//	container  ID prefix or name of a container using the volume
+//	bind       true for bind-mounts
+//	path       glob matching the host path
+//	name       glob matching a volume name or its container name
+//	rw         true for volumes mounted read-write
+type Filters map[string][]string
+
+var validFilters = map[string]bool{
+	"dangling":  true,
+	"container": false,
+	"bind":      true,
+	"path":      false,
+	"name":      false,
+	"rw":        true,
+}
+
+// ParseFilters parses filters in the form of `key=value`
+func ParseFilters(args []string) (Filters, error) {
+	filters := make(Filters)
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad format of filter, expected key=value: %s", arg)
+		}
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+
+		isBool, valid := validFilters[key]
+		if !valid {
+			return nil, fmt.Errorf("invalid filter: %s", key)
+		}
+		if isBool {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid value for filter %s, expected true or false: %s", key, value)
+			}
+		} else if key == "path" || key == "name" {
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern for filter %s: %s", key, value)
+			}
+		}
+		filters[key] = append(filters[key], value)
+	}
+	return filters, nil
+}
+
+// Match returns true if the volume satisfies all of the filters
+func (f Filters) Match(v *Volume) bool {
+	for key, values := range f {
+		var match bool
+		for _, value := range values {
+			if f.matchOne(key, value, v) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filters) matchOne(key, value string, v *Volume) bool {
+	switch key {
+	case "dangling":
+		b, _ := strconv.ParseBool(value)
+		return b == (len(v.Containers) == 0)
+	case "bind":
+		b, _ := strconv.ParseBool(value)
+		return b == v.IsBindMount
+	case "rw":
+		b, _ := strconv.ParseBool(value)
+		return b == v.IsReadWrite
+	case "path":
+		match, _ := path.Match(value, v.HostPath)
+		return match
+	case "name":
+		for _, n := range v.Names {
+			if match, _ := path.Match(value, n); match {
+				return true
+			}
+			if match, _ := path.Match(value, containerName(n)); match {
+				return true
+			}
+		}
+	case "container":
+		for _, id := range v.Containers {
+			if strings.HasPrefix(id, value) {
+				return true
+			}
+		}
+		for _, n := range v.Names {
+			if containerName(n) == strings.TrimPrefix(value, "/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containerName gets the container name part of a volume name, which is in the
+// form of `<container name>:<volume path>`
+func containerName(volName string) string {
+	return strings.SplitN(volName, ":", 2)[0]
+}
+
+func filterVolumes(vols []*Volume, filters Filters) []*Volume {
+	if len(filters) == 0 {
+		return vols
+	}
+	var out []*Volume
+	for _, v := range vols {
+		if filters.Match(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
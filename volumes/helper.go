@@ -0,0 +1,81 @@
+package volumes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// demuxOutput splits a multiplexed attach/logs stream into stdout and stderr.
+// Each frame is prefixed with an 8 byte header: the stream type in the first
+// byte and the big-endian payload size in the last 4 bytes.
+func demuxOutput(r io.Reader, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var w io.Writer
+		switch hdr[0] {
+		case 0, 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			return fmt.Errorf("unrecognized stream type in output: %d", hdr[0])
+		}
+
+		size := binary.BigEndian.Uint32(hdr[4:])
+		if _, err := io.CopyN(w, r, int64(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// RunHelper runs a short-lived helper container with the given config, waits
+// for it to exit and returns everything it wrote to stdout.
+func RunHelper(client Client, containerConfig map[string]interface{}) ([]byte, error) {
+	id, err := client.RunContainer(containerConfig)
+	defer client.RemoveContainer(id, true, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.ContainerWait(id); err != nil {
+		return nil, err
+	}
+
+	logs, err := client.ContainerLogs(id, false, true, true, false, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := demuxOutput(logs, &stdout, &stderr); err != nil {
+		return nil, err
+	}
+
+	c, err := client.FetchContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	if c.State.ExitCode != 0 {
+		return nil, fmt.Errorf("helper container exited with code %d: %s", c.State.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,332 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// BuildImportImage builds an image from an export archive, running it with the
+// volume to import to mounted at /.dockervolume copies the data into the
+// volume. This is how archives are imported into daemons older than API 1.20.
+func BuildImportImage(docker Client, context io.Reader, name string) (string, error) {
+	resp, err := docker.Build(context, name, false, true)
+	if err != nil {
+		return "", err
+	}
+	msgs := docker.DecodeStream(resp)
+	msgs = strings.Split(msgs[len(msgs)-1], " ")
+	imgId := msgs[len(msgs)-1]
+
+	return imgId, nil
+}
+
+// CreateVolume creates a new volume at volPath in a data-only container, which
+// is left in place to hold on to the volume
+func CreateVolume(docker Client, volPath string) (*Mount, error) {
+	containerConfig := map[string]interface{}{
+		"Image": docker.HelperImage(),
+		"Cmd":   []string{"/bin/sh", "-c", "true"},
+		"Volumes": map[string]struct{}{
+			volPath: struct{}{},
+		},
+	}
+	id, err := docker.RunContainer(containerConfig)
+	if err != nil {
+		docker.RemoveContainer(id, true, true)
+		return nil, err
+	}
+	docker.ContainerWait(id)
+
+	c, err := docker.FetchContainer(id)
+	if err != nil {
+		docker.RemoveContainer(id, true, true)
+		return nil, err
+	}
+	vols, err := docker.ContainerVolumes(c)
+	if err != nil {
+		docker.RemoveContainer(id, true, true)
+		return nil, err
+	}
+	vol, exists := vols[volPath]
+	if !exists {
+		docker.RemoveContainer(id, true, true)
+		return nil, fmt.Errorf("volume was not created at %s", volPath)
+	}
+	return vol, nil
+}
+
+// ExtractVolumePath gets the path the volume was at in the container it was
+// exported from, out of the config.json in an image from BuildImportImage
+func ExtractVolumePath(docker Client, imgId string) (string, error) {
+	extractVolInfoConfig := map[string]interface{}{
+		"Image": imgId,
+		"Cmd":   []string{"/bin/sh", "-c", "true"},
+	}
+	cid1, err := docker.RunContainer(extractVolInfoConfig)
+	if err != nil {
+		return "", fmt.Errorf("could not extract volume config: %v", err)
+	}
+	defer docker.RemoveContainer(cid1, true, true)
+	docker.ContainerWait(cid1)
+
+	tmpArch, err := docker.Copy(cid1, "/.volData/config.json")
+	if err != nil {
+		return "", fmt.Errorf("could not extract volume config: %v", err)
+	}
+
+	var configFile bytes.Buffer
+	if err := copyTarFile(tmpArch, "config.json", &configFile); err != nil {
+		return "", fmt.Errorf("could not untar archive: %v", err)
+	}
+	var volConfig Volume
+	if err := json.NewDecoder(&configFile).Decode(&volConfig); err != nil {
+		return "", fmt.Errorf("could not read config.json: %v", err)
+	}
+
+	return volConfig.VolPath, nil
+}
+
+// RunImportImage runs an image from BuildImportImage with the volume at
+// hostPath mounted, which copies the archived data into the volume. The image
+// is removed afterwards.
+func RunImportImage(docker Client, imgId, hostPath string) error {
+	bindSpec := fmt.Sprintf("%s:/.dockervolume", hostPath)
+	containerConfig := map[string]interface{}{
+		"Image": imgId,
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{bindSpec},
+		},
+	}
+	id, err := docker.RunContainer(containerConfig)
+	if err != nil {
+		docker.RemoveImage(imgId, true, false)
+		docker.RemoveContainer(id, true, true)
+		return err
+	}
+	docker.ContainerWait(id)
+	docker.RemoveImage(imgId, true, false)
+	docker.RemoveContainer(id, true, true)
+	return nil
+}
+
+// ImportSource is an export archive being imported straight into a volume,
+// without building an image from it. Needs Docker API 1.20 or newer.
+type ImportSource struct {
+	tr *tar.Reader
+	// next is the first entry after the Dockerfile and config.json, which has
+	// already been read to get to those
+	next *tar.Header
+	// Config is the volume config from config.json, nil if the archive has none
+	// ahead of the data
+	Config *Volume
+	// Manifest is only set if the archive was verified
+	Manifest *Manifest
+	// Owners rewrites the ownership of the entries, they are left as they are
+	// in the archive if nil
+	Owners  *OwnerMap
+	closers []io.Closer
+
+	whiteouts []string
+	links     []string
+	extracted map[string]bool
+}
+
+// ImportOptions are the optional settings for OpenImport
+type ImportOptions struct {
+	// SkipVerify streams the archive straight in without checking it against
+	// its manifest, needed for archives from older versions which have none.
+	// Otherwise the whole archive is copied to a temp file first.
+	SkipVerify bool
+	// VerifyKey is the key the archive must be signed with, if set
+	VerifyKey ed25519.PublicKey
+	// Owners rewrites the ownership of the files in the archive
+	Owners *OwnerMap
+}
+
+// OpenImport gets an uncompressed export archive ready for import, r is closed
+// along with the ImportSource if it is an io.Closer.
+// Unless SkipVerify is set, the archive is checked against its manifest, and
+// its signature if a key is set, before anything is returned. It is kept in a
+// temp file in os.TempDir meanwhile, so nothing is written to the volume from
+// an archive which does not check out. That needs as much free disk space as
+// the archive is big, with or without a key.
+func OpenImport(r io.Reader, opts ImportOptions) (*ImportSource, error) {
+	src := &ImportSource{Owners: opts.Owners, extracted: make(map[string]bool)}
+	if c, ok := r.(io.Closer); ok {
+		src.closers = append(src.closers, c)
+	}
+
+	if !opts.SkipVerify {
+		f, m, err := SpoolVerified(r, opts.VerifyKey)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("refusing to import archive: %w", err)
+		}
+		src.closers = append(src.closers, f)
+		src.Manifest = m
+		r = f
+	}
+
+	src.tr = tar.NewReader(r)
+	for {
+		hdr, err := src.tr.Next()
+		if err == io.EOF {
+			return src, nil
+		}
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("could not read import archive: %v", err)
+		}
+		switch importEntryName(hdr.Name) {
+		case "Dockerfile":
+		case "config.json":
+			src.Config = &Volume{}
+			if err := json.NewDecoder(src.tr).Decode(src.Config); err != nil {
+				src.Close()
+				return nil, fmt.Errorf("could not read config.json: %v", err)
+			}
+		default:
+			src.next = hdr
+			return src, nil
+		}
+	}
+}
+
+// importEntryName cleans up the leading `./` of archives made by busybox tar
+func importEntryName(name string) string {
+	return strings.TrimPrefix(name, "./")
+}
+
+// writeData writes the volume data from the archive to w as a tar stream
+// relative to the volume, picking up the whiteouts and links of incremental
+// archives on the way
+func (s *ImportSource) writeData(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for hdr := s.next; hdr != nil; {
+		name := importEntryName(hdr.Name)
+		switch {
+		case name == "data" || strings.HasPrefix(name, "data/"):
+			hdr.Name = strings.TrimPrefix(strings.TrimPrefix(name, "data"), "/")
+			if hdr.Name == "" {
+				hdr.Name = "./"
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = volumeRelPath(importEntryName(hdr.Linkname))
+			}
+			s.Owners.apply(hdr)
+			s.extracted[strings.TrimSuffix(hdr.Name, "/")] = true
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, s.tr); err != nil {
+				return err
+			}
+		case name == "whiteouts" || name == "links":
+			list, err := ioutil.ReadAll(s.tr)
+			if err != nil {
+				return err
+			}
+			if name == "whiteouts" {
+				s.whiteouts = splitNulList(list)
+			} else {
+				s.links = splitNulList(list)
+			}
+		}
+
+		var err error
+		hdr, err = s.tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read import archive: %v", err)
+		}
+	}
+	return tw.Close()
+}
+
+func (s *ImportSource) Close() error {
+	for _, c := range s.closers {
+		c.Close()
+	}
+	return nil
+}
+
+func splitNulList(b []byte) []string {
+	var items []string
+	for _, i := range bytes.Split(b, []byte{0}) {
+		if len(i) > 0 {
+			items = append(items, string(i))
+		}
+	}
+	return items
+}
+
+// Extract writes the volume data from the archive into the volume at hostPath
+// with the archive upload API, which keeps the ownership, modes, times and
+// xattrs of every file.
+// Files deleted in an incremental archive are removed afterwards, the daemon
+// already replaces anything which changed type when extracting.
+func (s *ImportSource) Extract(docker Client, hostPath string) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(s.writeData(w))
+	}()
+	err := putVolumeArchive(docker, hostPath, r)
+	r.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+
+	var deleted []string
+	for _, p := range s.whiteouts {
+		if !s.extracted[p] {
+			deleted = append(deleted, p)
+		}
+	}
+	if err := runVolumeScript(docker, hostPath, `rm -rf -- "$@"`, deleted, 1); err != nil {
+		return fmt.Errorf("could not remove deleted files: %v", err)
+	}
+	return runVolumeScript(docker, hostPath, `while [ $# -gt 1 ]; do ln -f -- "$1" "$2"; shift 2; done`, s.links, 2)
+}
+
+// maxScriptArgsSize keeps the args passed to a helper well below ARG_MAX
+const maxScriptArgsSize = 64 * 1024
+
+// runVolumeScript runs the shell script in the volume at hostPath with the
+// args as its positional parameters, across as many helpers as needed to keep
+// the command line short. Args are kept together in groups of n.
+func runVolumeScript(docker Client, hostPath, script string, args []string, n int) error {
+	for len(args) > 0 {
+		i, size := 0, 0
+		for i < len(args) && (i == 0 || size < maxScriptArgsSize) {
+			end := i + n
+			if end > len(args) {
+				end = len(args)
+			}
+			for _, a := range args[i:end] {
+				size += len(a) + 1
+			}
+			i = end
+		}
+
+		containerConfig := map[string]interface{}{
+			"Image": docker.HelperImage(),
+			"Cmd":   append([]string{"/bin/sh", "-c", "cd /.dockervolume && " + script, "sh"}, args[:i]...),
+			"HostConfig": map[string]interface{}{
+				"Binds": []string{hostPath + ":/.dockervolume"},
+			},
+		}
+		if _, err := RunHelper(docker, containerConfig); err != nil {
+			return err
+		}
+		args = args[i:]
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// IncrementDockerfile is used by incremental exports instead of
+// ExportDockerfile.
+// Before the changed files are copied in, the paths listed in `whiteouts` are
+// removed, which covers deleted files and files which changed type. Hard links
+// to files which did not change are not in the archive and are recreated from
+// the pairs of paths in `links` at the end.
+var IncrementDockerfile = `
+FROM %s
+ADD data /.volData
+ADD config.json /.volData/config.json
+ADD whiteouts /.volWhiteouts
+ADD links /.volLinks
+CMD cd /.dockervolume && xargs -0 -r rm -rf -- < /.volWhiteouts && rm /.volData/config.json && cp -a /.volData/. /.dockervolume/ && xargs -0 -r -n2 ln -f -- < /.volLinks
+`
+
+// unchangedSpoolLimit is the size up to which file content is held in memory
+// while checking if it changed, anything bigger goes to a temp file
+const unchangedSpoolLimit = 1 << 20
+
+// manifestState gets all the volume's files at the time of the export the
+// manifest is from, keyed by path without the trailing slash of dirs
+func manifestState(m *Manifest) map[string]ManifestEntry {
+	state := make(map[string]ManifestEntry)
+	for _, list := range [][]ManifestEntry{m.Files, m.Unchanged} {
+		for _, e := range list {
+			if strings.HasPrefix(e.Path, "data/") {
+				state[strings.TrimSuffix(e.Path, "/")] = e
+			}
+		}
+	}
+	return state
+}
+
+// writeIncrementalArchive writes out an export archive with only the entries
+// from the volume's tar stream which are new or changed since the base.
+// The content of every file is still read from the daemon, files the same size
+// as in the base are held back until their digest shows if they changed.
+func writeIncrementalArchive(aw *archiveWriter, data io.Reader, config []byte, opts exportOptions) error {
+	base := opts.base
+	if err := aw.WriteFile("Dockerfile", []byte(fmt.Sprintf(IncrementDockerfile, opts.helperImage))); err != nil {
+		return err
+	}
+	if err := aw.WriteFile("config.json", config); err != nil {
+		return err
+	}
+
+	var (
+		state     = manifestState(base)
+		seen      = make(map[string]bool)
+		skipped   = make(map[string]bool)
+		whiteouts []string
+		links     []string
+	)
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read volume archive: %s", err)
+		}
+
+		hdr.Name = rebaseExportPath(hdr.Name)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = rebaseExportPath(hdr.Linkname)
+		}
+		opts.annotate(hdr)
+		p := strings.TrimSuffix(hdr.Name, "/")
+		seen[p] = true
+
+		entry := newManifestEntry(hdr)
+		prev, existed := state[p]
+		if existed && prev.Type != entry.Type {
+			whiteouts = append(whiteouts, volumeRelPath(p))
+			existed = false
+		}
+
+		switch {
+		case entry.Type == "dir":
+			// dirs are cheap and always included, `data/` has to be there anyway
+		case entry.Type == "link" && skipped[hdr.Linkname]:
+			links = append(links, volumeRelPath(hdr.Linkname), volumeRelPath(p))
+			aw.manifest.Unchanged = append(aw.manifest.Unchanged, entry)
+			continue
+		case entry.Type == "file" && existed && prev.Size == entry.Size:
+			unchanged, err := writeIfChanged(aw, hdr, tr, prev)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				skipped[p] = true
+			}
+			continue
+		case entry.Type != "file" && existed && prev == entry:
+			aw.manifest.Unchanged = append(aw.manifest.Unchanged, entry)
+			continue
+		case entry.Type == "symlink" && existed:
+			// cp would write through the old link
+			whiteouts = append(whiteouts, volumeRelPath(p))
+		}
+		if err := aw.WriteEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+
+	for p := range state {
+		if !seen[p] && p != "data" {
+			whiteouts = append(whiteouts, volumeRelPath(p))
+		}
+	}
+	if err := aw.WriteFile("whiteouts", nulList(whiteouts)); err != nil {
+		return err
+	}
+	if err := aw.WriteFile("links", nulList(links)); err != nil {
+		return err
+	}
+
+	aw.manifest.Base = base.ID
+	return aw.Close()
+}
+
+// writeIfChanged reads in the file content and only adds it to the archive if
+// it does not match the entry from the base, otherwise it is recorded as
+// unchanged
+func writeIfChanged(aw *archiveWriter, hdr *tar.Header, content io.Reader, prev ManifestEntry) (bool, error) {
+	var spool io.ReadWriter = bytes.NewBuffer(nil)
+	if hdr.Size > unchangedSpoolLimit {
+		f, err := ioutil.TempFile("", "docker-volumes-export")
+		if err != nil {
+			return false, err
+		}
+		os.Remove(f.Name())
+		defer f.Close()
+		spool = f
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spool, h), content); err != nil {
+		return false, fmt.Errorf("Could not read volume archive: %s", err)
+	}
+	entry := newManifestEntry(hdr)
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	if entry == prev {
+		aw.manifest.Unchanged = append(aw.manifest.Unchanged, entry)
+		return true, nil
+	}
+
+	if f, ok := spool.(*os.File); ok {
+		if _, err := f.Seek(0, 0); err != nil {
+			return false, err
+		}
+	}
+	return false, aw.WriteEntry(hdr, spool)
+}
+
+// volumeRelPath turns a path in the archive into one relative to the volume
+func volumeRelPath(p string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(p, "/"), "data/")
+}
+
+func nulList(items []string) []byte {
+	var b bytes.Buffer
+	for _, i := range items {
+		b.WriteString(i)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
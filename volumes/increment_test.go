@@ -0,0 +1,138 @@
+package volumes_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+	"github.com/cpuguy83/docker-volumes/volumes/volumestest"
+)
+
+// runScripts stands in for the scripts Extract runs in the volume, which
+// remove the deleted files and recreate the hard links
+func runScripts(f *volumestest.Daemon, c *volumestest.Container) (string, string, int) {
+	cmd := c.Cmd()
+	if len(cmd) < 4 {
+		return "", "", 0
+	}
+	v := f.MountedVolume(c, "/.dockervolume")
+	script, args := cmd[2], cmd[4:]
+	switch {
+	case strings.Contains(script, "rm -rf"):
+		for _, p := range args {
+			v.Remove(p)
+		}
+	case strings.Contains(script, "ln -f"):
+		for i := 0; i+1 < len(args); i += 2 {
+			v.Link(args[i], args[i+1])
+		}
+	}
+	return "", "", 0
+}
+
+func exportVolume(t *testing.T, d *volumestest.Daemon, store *volumes.Store, id string, base *volumes.Manifest) []byte {
+	t.Helper()
+	var arch bytes.Buffer
+	opts := volumes.ExportOptions{NumericOwner: true, Base: base}
+	if err := store.Export(context.Background(), d, store.Get(id), &arch, opts); err != nil {
+		t.Fatal(err)
+	}
+	return arch.Bytes()
+}
+
+func importVolume(t *testing.T, d *volumestest.Daemon, arch []byte, hostPath string) *volumes.Manifest {
+	t.Helper()
+	imp, err := volumes.OpenImport(bytes.NewReader(arch), volumes.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+	if err := imp.Extract(d, hostPath); err != nil {
+		t.Fatal(err)
+	}
+	return imp.Manifest
+}
+
+// archiveList gets the paths in the NUL separated list name of the archive
+func archiveList(t *testing.T, arch []byte, name string) []string {
+	t.Helper()
+	tr := tar.NewReader(bytes.NewReader(arch))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("no %s in the archive: %v", name, err)
+		}
+		if hdr.Name != name {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list := strings.Split(strings.TrimSuffix(string(b), "\x00"), "\x00")
+		sort.Strings(list)
+		return list
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	d := volumestest.NewDaemon()
+	d.Run = runScripts
+	big := bytes.Repeat([]byte("unchanged "), 1000)
+	web := d.AddVolume("web", map[string][]byte{
+		"index.html": []byte("hello"),
+		"old.txt":    []byte("deleted"),
+		"conf":       []byte("a file"),
+		"big":        big,
+	})
+	d.AddVolume("copy", nil)
+	d.AddContainer("web", map[string]string{"/data": web})
+	store := load(t, d)
+
+	base := importVolume(t, d, exportVolume(t, d, store, "web", nil), store.Get("copy").HostPath)
+
+	src := d.Volume(web)
+	src.Remove("old.txt")
+	src.Remove("conf")
+	src.Files["conf/site.conf"] = []byte("a dir now")
+	src.Link("big", "big-link")
+
+	incr := exportVolume(t, d, store, "web", base)
+	if bytes.Contains(incr, big) {
+		t.Fatal("expected the unchanged file to be left out of the increment")
+	}
+	if whiteouts := archiveList(t, incr, "whiteouts"); !equal(whiteouts, []string{"conf", "old.txt"}) {
+		t.Fatalf("expected the deleted file and the file which became a dir as whiteouts, got %q", whiteouts)
+	}
+	if links := archiveList(t, incr, "links"); !equal(links, []string{"big", "big-link"}) {
+		t.Fatalf("expected the link to the unchanged file in links, got %q", links)
+	}
+	m := importVolume(t, d, incr, store.Get("copy").HostPath)
+	if m.Base != base.ID {
+		t.Fatalf("expected the increment to be based on %s, got %s", base.ID, m.Base)
+	}
+
+	dst := d.Volume(store.Get("copy").HostPath)
+	if len(dst.Files) != len(src.Files) {
+		t.Fatalf("expected %d files, got %d", len(src.Files), len(dst.Files))
+	}
+	for name, data := range src.Files {
+		if !bytes.Equal(dst.Files[name], data) {
+			t.Fatalf("%s: expected %d bytes, got %q", name, len(data), dst.Files[name])
+		}
+	}
+	if _, exists := dst.Files["old.txt"]; exists {
+		t.Fatal("expected the deleted file to be removed")
+	}
+	if _, exists := dst.Files["conf"]; exists {
+		t.Fatal("expected the file to be replaced by a dir")
+	}
+	if dst.Links["big-link"] != "big" {
+		t.Fatalf("expected big-link to be a hard link to big, got %q", dst.Links["big-link"])
+	}
+}
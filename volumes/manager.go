@@ -0,0 +1,109 @@
+package volumes
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Manager manages the volumes of a Docker host.
+// Each call looks up the volumes afresh, use Load and the Store directly to
+// work on the same set of volumes across calls.
+type Manager struct {
+	client Client
+	opts   LoadOptions
+}
+
+// NewManager creates a Manager for the volumes of the Docker host the client
+// is connected to
+func NewManager(client Client, opts LoadOptions) *Manager {
+	return &Manager{client: client, opts: opts}
+}
+
+// ListOptions are the optional settings for List
+type ListOptions struct {
+	// Filters only lists the volumes which match them
+	Filters Filters
+}
+
+// List gets all the volumes on the Docker host
+func (m *Manager) List(ctx context.Context, opts ListOptions) ([]*Volume, error) {
+	store, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterVolumes(store.List(), opts.Filters), nil
+}
+
+// Get gets a volume by ID, name or ID prefix
+func (m *Manager) Get(ctx context.Context, id string) (*Volume, error) {
+	store, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.Find(id)
+}
+
+// Remove deletes the volumes, see Store.Remove. Nothing is removed if any of
+// them can not be found.
+func (m *Manager) Remove(ctx context.Context, ids ...string) error {
+	store, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+	var vols []*Volume
+	for _, id := range ids {
+		v, err := store.Find(id)
+		if err != nil {
+			return err
+		}
+		vols = append(vols, v)
+	}
+	return store.Remove(m.client, vols)
+}
+
+// Export writes an export archive of the volume to w, see Store.Export
+func (m *Manager) Export(ctx context.Context, id string, w io.Writer, opts ExportOptions) error {
+	store, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+	v, err := store.Find(id)
+	if err != nil {
+		return err
+	}
+	return store.Export(ctx, m.client, v, w, opts)
+}
+
+// Import extracts an uncompressed export archive into the volume, the data
+// already in the volume is kept unless the archive has the same files.
+// Needs Docker API 1.20 or newer.
+func (m *Manager) Import(ctx context.Context, id string, r io.Reader, opts ImportOptions) error {
+	store, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+	if store.APIVersion.LessThan("1.20") {
+		return fmt.Errorf("importing without building an image needs Docker API 1.20 or newer")
+	}
+	v, err := store.Find(id)
+	if err != nil {
+		return err
+	}
+	src, err := OpenImport(r, opts)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return src.Extract(m.client, v.HostPath)
+}
+
+func (m *Manager) load(ctx context.Context) (*Store, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return load(ctx, m.client, m.opts)
+}
@@ -0,0 +1,133 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OwnerMap rewrites the ownership of the entries being imported.
+// Unless Numeric is set, user and group names from the archive are looked up
+// in Users and Groups, the same as tar does, falling back to the IDs from the
+// archive. The UIDs and GIDs maps are applied after that.
+type OwnerMap struct {
+	Numeric bool
+	// Users and Groups map names to IDs, usually from ContainerOwners for the
+	// container being imported to
+	Users  map[string]int
+	Groups map[string]int
+	UIDs   map[int]int
+	GIDs   map[int]int
+}
+
+func (m *OwnerMap) apply(hdr *tar.Header) {
+	if m == nil {
+		return
+	}
+	if !m.Numeric {
+		if id, exists := m.Users[hdr.Uname]; exists && hdr.Uname != "" {
+			hdr.Uid = id
+		}
+		if id, exists := m.Groups[hdr.Gname]; exists && hdr.Gname != "" {
+			hdr.Gid = id
+		}
+	}
+	if id, exists := m.UIDs[hdr.Uid]; exists {
+		hdr.Uid = id
+	}
+	if id, exists := m.GIDs[hdr.Gid]; exists {
+		hdr.Gid = id
+	}
+}
+
+// ownerNames sets the user and group names of exported entries from the
+// source container's passwd and group files.
+// The names the daemon puts in archives are looked up on the daemon's host,
+// which need not have anything to do with the users in the container.
+type ownerNames struct {
+	users  map[int]string
+	groups map[int]string
+}
+
+func (n *ownerNames) apply(hdr *tar.Header) {
+	hdr.Uname, hdr.Gname = "", ""
+	if n != nil {
+		hdr.Uname = n.users[hdr.Uid]
+		hdr.Gname = n.groups[hdr.Gid]
+	}
+}
+
+// ContainerOwners reads the users and groups, name to ID, from the container's
+// /etc/passwd and /etc/group
+func ContainerOwners(docker Client, id string) (users, groups map[string]int, err error) {
+	if users, err = readIDFile(docker, id, "/etc/passwd"); err != nil {
+		return nil, nil, err
+	}
+	if groups, err = readIDFile(docker, id, "/etc/group"); err != nil {
+		return nil, nil, err
+	}
+	return users, groups, nil
+}
+
+// readIDFile reads the name and ID columns of a passwd or group file from the
+// container
+func readIDFile(docker Client, id, p string) (map[string]int, error) {
+	arch, err := docker.GetArchive(id, p)
+	if err != nil {
+		return nil, err
+	}
+	defer arch.Close()
+
+	tr := tar.NewReader(arch)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", p, err)
+	}
+	return parseIDFile(tr)
+}
+
+func parseIDFile(r io.Reader) (map[string]int, error) {
+	ids := make(map[string]int)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if _, exists := ids[fields[0]]; !exists {
+			ids[fields[0]] = id
+		}
+	}
+	return ids, s.Err()
+}
+
+// exportOwnerNames gets the names for the owners of the volume's files from
+// the first container using it, nil is returned if there is none or it has no
+// passwd and group files
+func exportOwnerNames(docker Client, v *Volume) *ownerNames {
+	if len(v.Containers) == 0 {
+		return nil
+	}
+	users, groups, err := ContainerOwners(docker, v.Containers[0])
+	if err != nil {
+		return nil
+	}
+	n := &ownerNames{users: make(map[int]string), groups: make(map[int]string)}
+	for name, id := range users {
+		if _, exists := n.users[id]; !exists || name < n.users[id] {
+			n.users[id] = name
+		}
+	}
+	for name, id := range groups {
+		if _, exists := n.groups[id]; !exists || name < n.groups[id] {
+			n.groups[id] = name
+		}
+	}
+	return n
+}
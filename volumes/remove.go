@@ -0,0 +1,165 @@
+package volumes
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Remove deletes the volumes. ErrInUse is returned without removing anything
+// if any of them is used by a container, and bind-mounts are never removed as
+// their data is a dir on the host rather than a volume.
+// With API 1.21 or newer the volumes are removed through the daemon, each
+// volume which could not be removed gets its own error. Otherwise their data
+// is removed with a single helper container, which either removes all of them
+// or fails.
+func (s *Store) Remove(client Client, vols []*Volume) error {
+	if len(vols) == 0 {
+		return nil
+	}
+	for _, v := range vols {
+		if !s.CanRemove(v) {
+			return fmt.Errorf("%w: %s is used by %s", ErrInUse, v.ID, strings.Join(v.Names, ", "))
+		}
+		if v.IsBindMount {
+			return fmt.Errorf("%s is a bind-mount of %s, refusing to remove it", v.ID, v.HostPath)
+		}
+	}
+
+	if s.APIVersion.GreaterThanOrEqualTo("1.21") {
+		var errs []error
+		for _, v := range vols {
+			if err := client.RemoveVolume(v.ID); err != nil {
+				errs = append(errs, fmt.Errorf("could not remove volume %s: %w", v.ID, err))
+				continue
+			}
+			delete(s.s, v.ID)
+		}
+		return errors.Join(errs...)
+	}
+
+	if err := removeVolumeDirs(client, s.APIVersion.LessThan("1.19"), vols); err != nil {
+		return fmt.Errorf("could not remove volumes: %w", err)
+	}
+	for _, v := range vols {
+		delete(s.s, v.ID)
+	}
+	return nil
+}
+
+// removeVolumeDirs removes the dirs of volumes from daemons without the volume
+// API, along with their config dirs from before API 1.19 if separateConfig is
+// set.
+// Each distinct parent dir is only bind-mounted once, so removing any number
+// of volumes from the docker root only needs a mount or two.
+func removeVolumeDirs(client Client, separateConfig bool, vols []*Volume) error {
+	var (
+		binds   []string
+		cmds    []string
+		volumes = make(map[string]struct{})
+		mounts  = make(map[string]string)
+	)
+	mount := func(hostPath string) string {
+		if mnt, exists := mounts[hostPath]; exists {
+			return mnt
+		}
+		mnt := fmt.Sprintf("/.dockervolumes/%d", len(mounts))
+		mounts[hostPath] = mnt
+		volumes[mnt] = struct{}{}
+		binds = append(binds, hostPath+":"+mnt)
+		return mnt
+	}
+
+	for _, v := range vols {
+		if err := checkVolumeDir(v.HostPath); err != nil {
+			return err
+		}
+		hostMountPath, name := path.Split(v.HostPath)
+		cmds = append(cmds, "rm -rf "+path.Join(mount(hostMountPath), name))
+
+		// Before 1.19 the volume config lived separately from the volume data
+		if separateConfig {
+			hostConfPath := strings.TrimSuffix(hostMountPath, "/vfs/dir/") + "/volumes"
+			cmds = append(cmds, "rm -rf "+path.Join(mount(hostConfPath), name))
+		}
+	}
+
+	containerConfig := map[string]interface{}{
+		"Image":      client.HelperImage(),
+		"Entrypoint": []string{"/bin/sh", "-c"},
+		"Cmd":        []string{strings.Join(cmds, " && ")},
+		"Volumes":    volumes,
+		"HostConfig": map[string]interface{}{
+			"Binds": binds,
+		},
+	}
+	_, err := RunHelper(client, containerConfig)
+	return err
+}
+
+// checkVolumeDir makes sure the path of a volume can't lead to removing
+// anything other than the volume's own dir
+func checkVolumeDir(hostPath string) error {
+	if !path.IsAbs(hostPath) || path.Clean(hostPath) != hostPath || path.Dir(hostPath) == "/" {
+		return fmt.Errorf("refusing to remove volume at unexpected path %q", hostPath)
+	}
+	if strings.ContainsAny(hostPath, " \t\n'\"\\$`;&|<>()*?[]{}!#~") {
+		return fmt.Errorf("refusing to remove volume at path with special characters %q", hostPath)
+	}
+	return nil
+}
+
+// RunningContainers gets the names of the containers using the volume which
+// are running or paused, they have to be stopped before the volume can be
+// removed
+func RunningContainers(client Client, v *Volume) ([]string, error) {
+	var running []string
+	for _, id := range v.Containers {
+		c, err := client.FetchContainer(id)
+		if err != nil {
+			return nil, fmt.Errorf("could not inspect container %s: %v", id, err)
+		}
+		if c.State.Running || c.State.Paused {
+			running = append(running, strings.TrimPrefix(c.Name, "/"))
+		}
+	}
+	return running, nil
+}
+
+// RemoveContainers removes the containers using the volume, so the volume can
+// be removed. The volumes of the containers are kept.
+// ErrInUse is returned without removing any of them if one is running.
+func (s *Store) RemoveContainers(client Client, v *Volume) error {
+	running, err := RunningContainers(client, v)
+	if err != nil {
+		return err
+	}
+	if len(running) > 0 {
+		return fmt.Errorf("%w: %s is used by running containers %s", ErrInUse, v.ID, strings.Join(running, ", "))
+	}
+
+	for _, id := range append([]string(nil), v.Containers...) {
+		if err := client.RemoveContainer(id, false, false); err != nil {
+			return fmt.Errorf("could not remove container %s: %v", id, err)
+		}
+		s.dropContainer(id)
+	}
+	return nil
+}
+
+// dropContainer removes a container which is gone from the volumes it used
+func (s *Store) dropContainer(id string) {
+	for _, v := range s.s {
+		for i := 0; i < len(v.Containers); i++ {
+			if v.Containers[i] != id {
+				continue
+			}
+			v.Containers = append(v.Containers[:i], v.Containers[i+1:]...)
+			if i < len(v.Names) {
+				v.Names = append(v.Names[:i], v.Names[i+1:]...)
+			}
+			i--
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package volumes
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// signatureName is the name of the signature in signed export archives, it
+// follows right after the manifest.
+// Only the manifest is signed, it holds the digest of every other entry in the
+// archive, including config.json, so the signature covers all of them.
+const signatureName = "manifest.sig"
+
+const signatureContext = "docker-volumes manifest signature\x00"
+
+// ErrUnsigned is returned when verifying the signature of an unsigned archive
+var ErrUnsigned = errors.New("archive is not signed")
+
+// Signature is the ed25519 signature of the manifest of an export archive
+type Signature struct {
+	KeyID     string
+	Signature []byte
+}
+
+func signManifest(key ed25519.PrivateKey, manifest []byte) ([]byte, error) {
+	pub := key.Public().(ed25519.PublicKey)
+	return json.MarshalIndent(Signature{
+		KeyID:     KeyID(pub),
+		Signature: ed25519.Sign(key, append([]byte(signatureContext), manifest...)),
+	}, "", "	")
+}
+
+func verifyManifestSignature(key ed25519.PublicKey, manifest []byte, sig *Signature) error {
+	if sig == nil {
+		return ErrUnsigned
+	}
+	if id := KeyID(key); sig.KeyID != id {
+		return fmt.Errorf("archive is signed with key %s, not %s", sig.KeyID, id)
+	}
+	if !ed25519.Verify(key, append([]byte(signatureContext), manifest...), sig.Signature) {
+		return fmt.Errorf("signature does not match, the archive has been tampered with")
+	}
+	return nil
+}
+
+// KeyID gets a short fingerprint of a key, used to tell keys apart without
+// giving away anything about the key itself
+func KeyID(key []byte) string {
+	h := sha256.Sum256(append([]byte("docker-volumes key id\x00"), key...))
+	return hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,79 @@
+package volumes
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is the disk usage of a volume
+type Size struct {
+	// Apparent is the sum of the sizes of all regular files in the volume
+	Apparent int64
+	// Disk is the space actually allocated on disk for the volume
+	Disk int64
+}
+
+// Sizes calculates the size of each of the passed in volumes, keyed by
+// volume ID.
+// Since we don't have access to the host FS, all the volumes get bind-mounted
+// into a single busybox container which reports on them.
+func Sizes(client Client, vols []*Volume) (map[string]Size, error) {
+	sizes := make(map[string]Size)
+	if len(vols) == 0 {
+		return sizes, nil
+	}
+
+	var (
+		binds   []string
+		cmds    []string
+		volumes = make(map[string]struct{})
+	)
+	for i, v := range vols {
+		mnt := fmt.Sprintf("/.dockervolumes/%d", i)
+		binds = append(binds, v.HostPath+":"+mnt+":ro")
+		volumes[mnt] = struct{}{}
+		cmds = append(cmds, fmt.Sprintf(
+			"echo %d $(find %s -type f -exec stat -c %%s {} + | awk '{s+=$1} END {print s+0}') $(du -sk %s | cut -f1)",
+			i, mnt, mnt,
+		))
+	}
+
+	containerConfig := map[string]interface{}{
+		"Image":   client.HelperImage(),
+		"Cmd":     []string{"/bin/sh", "-c", strings.Join(cmds, "; ")},
+		"Volumes": volumes,
+		"HostConfig": map[string]interface{}{
+			"Binds": binds,
+		},
+	}
+
+	out, err := RunHelper(client, containerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not calculate volume sizes: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		i, err := strconv.Atoi(fields[0])
+		if err != nil || i < 0 || i >= len(vols) {
+			continue
+		}
+		apparent, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse size for %s: %v", vols[i].ID, err)
+		}
+		disk, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse disk usage for %s: %v", vols[i].ID, err)
+		}
+		sizes[vols[i].ID] = Size{Apparent: apparent, Disk: disk * 1024}
+	}
+	return sizes, scanner.Err()
+}
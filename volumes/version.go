@@ -0,0 +1,52 @@
+package volumes
+
+import (
+	"strconv"
+	"strings"
+)
+
+// APIVersion is a Docker remote API version, eg. "1.21"
+type APIVersion string
+
+// compareTo compares the dot separated numbers of the versions, missing ones
+// count as 0
+func (v APIVersion) compareTo(other APIVersion) int {
+	cur := strings.Split(string(v), ".")
+	oth := strings.Split(string(other), ".")
+	for i := 0; i < len(cur) || i < len(oth); i++ {
+		var a, b int
+		if i < len(cur) {
+			a, _ = strconv.Atoi(cur[i])
+		}
+		if i < len(oth) {
+			b, _ = strconv.Atoi(oth[i])
+		}
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v APIVersion) LessThan(other APIVersion) bool {
+	return v.compareTo(other) < 0
+}
+
+func (v APIVersion) LessThanOrEqualTo(other APIVersion) bool {
+	return v.compareTo(other) <= 0
+}
+
+func (v APIVersion) GreaterThan(other APIVersion) bool {
+	return v.compareTo(other) > 0
+}
+
+func (v APIVersion) GreaterThanOrEqualTo(other APIVersion) bool {
+	return v.compareTo(other) >= 0
+}
+
+func (v APIVersion) Equal(other APIVersion) bool {
+	return v.compareTo(other) == 0
+}
@@ -0,0 +1,142 @@
+package volumes
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Volume is a volume on the Docker host, along with the containers using it
+type Volume struct {
+	Mount
+	ID         string
+	Containers []string
+	Names      []string
+}
+
+// NewVolumeFromDocker sets up a Volume from a volume as reported by a
+// container, working out the ID and normalizing the host path across API
+// versions
+func NewVolumeFromDocker(vol *Mount) *Volume {
+	v := &Volume{Mount: *vol}
+
+	v.ID = v.Id()
+	if v.ID == "_data" {
+		v.ID = path.Base(path.Dir(v.HostPath))
+	}
+
+	if v.IsBindMount {
+		h := sha1.New()
+		h.Write([]byte(v.HostPath))
+		v.ID = fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	// Since API 1.19 the data is in a `_data` dir next to the volume config,
+	// paths under vfs/dir from older daemons never end with it
+	if strings.HasSuffix(v.HostPath, "_data") && !v.IsBindMount {
+		v.HostPath = path.Dir(v.HostPath)
+	}
+	return v
+}
+
+// Store holds the volumes of a Docker host, see Load
+type Store struct {
+	s map[string]*Volume
+	// APIVersion is the API version of the daemon the volumes are from
+	APIVersion APIVersion
+}
+
+func NewStore(apiVersion APIVersion) *Store {
+	return &Store{s: make(map[string]*Volume), APIVersion: apiVersion}
+}
+
+func (v *Store) Add(volume *Volume) {
+	v.s[volume.ID] = volume
+}
+
+func (v *Store) Get(id string) *Volume {
+	return v.s[id]
+}
+
+func (v *Store) List() []*Volume {
+	var vols []*Volume
+	for _, vol := range v.s {
+		vols = append(vols, vol)
+	}
+	return vols
+}
+
+func (v *Store) CanRemove(volume *Volume) bool {
+	if len(volume.Containers) != 0 {
+		return false
+	}
+	return true
+}
+
+// Find gets a volume by ID, name or a unique prefix of the ID, the same as
+// Docker does for containers. ErrNotFound is returned if none matches, and an
+// AmbiguousError if the name or prefix matches more than one volume.
+func (v *Store) Find(id string) (*Volume, error) {
+	if vol := v.Get(id); vol != nil {
+		return vol, nil
+	}
+
+	vol, err := v.FindByName(id)
+	if vol != nil || err != nil {
+		return vol, err
+	}
+
+	vol, err = v.FindByIDPrefix(id)
+	if vol != nil || err != nil {
+		return vol, err
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// FindByName gets the volume with the name, nil is returned if there is none
+// and an *AmbiguousError if more than one volume has it
+func (v *Store) FindByName(name string) (*Volume, error) {
+	var found []*Volume
+	for _, vol := range v.s {
+		for _, n := range vol.Names {
+			if n == name {
+				found = append(found, vol)
+				break
+			}
+		}
+	}
+	return oneOf(name, found)
+}
+
+// FindByIDPrefix gets the volume with an ID starting with prefix, nil is
+// returned if there is none and an *AmbiguousError if more than one matches
+func (v *Store) FindByIDPrefix(prefix string) (*Volume, error) {
+	if prefix == "" {
+		return nil, nil
+	}
+	var found []*Volume
+	for _, vol := range v.s {
+		if strings.HasPrefix(vol.ID, prefix) {
+			found = append(found, vol)
+		}
+	}
+	return oneOf(prefix, found)
+}
+
+func oneOf(id string, found []*Volume) (*Volume, error) {
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	e := &AmbiguousError{ID: id}
+	for _, vol := range found {
+		e.Candidates = append(e.Candidates, vol.ID)
+	}
+	sort.Strings(e.Candidates)
+	return nil, e
+}
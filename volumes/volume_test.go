@@ -0,0 +1,184 @@
+package volumes_test
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+	"github.com/cpuguy83/docker-volumes/volumes/volumestest"
+)
+
+// newDaemon sets up a daemon with the volumes web and logs used by the
+// container web, and an unused volume cache
+func newDaemon(t *testing.T) (*volumestest.Daemon, string) {
+	t.Helper()
+	d := volumestest.NewDaemon()
+	web := d.AddVolume("web", map[string][]byte{"index.html": []byte("hello")})
+	logs := d.AddVolume("logs", nil)
+	d.AddVolume("cache", nil)
+	id := d.AddContainer("web", map[string]string{"/data": web, "/logs": logs})
+	return d, id
+}
+
+func load(t *testing.T, d *volumestest.Daemon) *volumes.Store {
+	t.Helper()
+	store, err := volumes.Load(d, volumes.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestLoad(t *testing.T) {
+	d, id := newDaemon(t)
+
+	for _, parallel := range []int{0, 1, 4} {
+		store, err := volumes.Load(d, volumes.LoadOptions{Parallel: parallel})
+		if err != nil {
+			t.Fatalf("parallel %d: %v", parallel, err)
+		}
+
+		var ids []string
+		for _, v := range store.List() {
+			ids = append(ids, v.ID)
+		}
+		sort.Strings(ids)
+		if want := []string{"cache", "logs", "web"}; !equal(ids, want) {
+			t.Fatalf("parallel %d: expected volumes %v, got %v", parallel, want, ids)
+		}
+
+		web := store.Get("web")
+		if web.HostPath != "/var/lib/docker/volumes/web" {
+			t.Fatalf("expected the host path without _data, got %s", web.HostPath)
+		}
+		if !equal(web.Containers, []string{id}) || !equal(web.Names, []string{"web:/data"}) {
+			t.Fatalf("expected web to be used by %s as web:/data, got %v %v", id, web.Containers, web.Names)
+		}
+		if cache := store.Get("cache"); len(cache.Containers) != 0 || !store.CanRemove(cache) {
+			t.Fatalf("expected cache to be unused, got %v", cache.Containers)
+		}
+	}
+}
+
+func TestStoreFind(t *testing.T) {
+	d, _ := newDaemon(t)
+	store := load(t, d)
+
+	for _, id := range []string{"logs", "web:/logs"} {
+		v, err := store.Find(id)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if v.ID != "logs" {
+			t.Fatalf("%s: expected logs, got %s", id, v.ID)
+		}
+	}
+
+	if _, err := store.Find("nope"); !errors.Is(err, volumes.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreFindMatches(t *testing.T) {
+	store := volumes.NewStore("1.21")
+	for id, names := range map[string][]string{
+		"abc":    nil,
+		"abc123": {"web:/data"},
+		"abd456": {"shared:/data"},
+		"ffe000": {"shared:/data", "db:/var/lib/db"},
+	} {
+		store.Add(&volumes.Volume{ID: id, Names: names})
+	}
+
+	for _, tc := range []struct {
+		name       string
+		id         string
+		expected   string
+		candidates []string
+	}{
+		{name: "exact ID", id: "abc123", expected: "abc123"},
+		{name: "exact ID which prefixes others", id: "abc", expected: "abc"},
+		{name: "name", id: "web:/data", expected: "abc123"},
+		{name: "unique prefix", id: "ff", expected: "ffe000"},
+		{name: "ambiguous prefix", id: "ab", candidates: []string{"abc", "abc123", "abd456"}},
+		{name: "ambiguous name", id: "shared:/data", candidates: []string{"abd456", "ffe000"}},
+	} {
+		v, err := store.Find(tc.id)
+		if tc.candidates == nil {
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if v.ID != tc.expected {
+				t.Fatalf("%s: expected %s, got %s", tc.name, tc.expected, v.ID)
+			}
+			continue
+		}
+
+		var ambiguous *volumes.AmbiguousError
+		if !errors.As(err, &ambiguous) || !errors.Is(err, volumes.ErrAmbiguous) {
+			t.Fatalf("%s: expected an AmbiguousError, got %v", tc.name, err)
+		}
+		if v != nil {
+			t.Fatalf("%s: expected no volume, got %s", tc.name, v.ID)
+		}
+		if ambiguous.ID != tc.id || !equal(ambiguous.Candidates, tc.candidates) {
+			t.Fatalf("%s: expected %s to match %v, got %s matching %v", tc.name, tc.id, tc.candidates, ambiguous.ID, ambiguous.Candidates)
+		}
+	}
+
+	if _, err := store.Find("zz"); !errors.Is(err, volumes.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if v, err := store.FindByIDPrefix(""); v != nil || err != nil {
+		t.Fatalf("expected an empty prefix to match nothing, got %v %v", v, err)
+	}
+}
+
+func TestStoreRemove(t *testing.T) {
+	d, _ := newDaemon(t)
+	store := load(t, d)
+
+	err := store.Remove(d, []*volumes.Volume{store.Get("cache"), store.Get("web")})
+	if !errors.Is(err, volumes.ErrInUse) {
+		t.Fatalf("expected ErrInUse, got %v", err)
+	}
+	if d.Volume("/var/lib/docker/volumes/cache") == nil || store.Get("cache") == nil {
+		t.Fatal("expected nothing to be removed when a volume is in use")
+	}
+
+	if err := store.Remove(d, []*volumes.Volume{store.Get("cache")}); err != nil {
+		t.Fatal(err)
+	}
+	if d.Volume("/var/lib/docker/volumes/cache") != nil {
+		t.Fatal("expected cache to be removed from the daemon")
+	}
+	if store.Get("cache") != nil {
+		t.Fatal("expected cache to be removed from the store")
+	}
+	if _, err := store.Find("cache"); !errors.Is(err, volumes.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after removing, got %v", err)
+	}
+}
+
+func TestStoreRemoveBindMount(t *testing.T) {
+	store := volumes.NewStore("1.21")
+	v := volumes.NewVolumeFromDocker(&volumes.Mount{HostPath: "/srv/data", VolPath: "/data", IsBindMount: true})
+	store.Add(v)
+
+	if err := store.Remove(volumestest.NewDaemon(), []*volumes.Volume{v}); err == nil {
+		t.Fatal("expected bind-mounts to never be removed")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
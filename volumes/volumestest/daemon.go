@@ -0,0 +1,586 @@
+// Package volumestest has an in-memory Docker daemon, to use the volumes
+// package without a real one.
+package volumestest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cpuguy83/docker-volumes/volumes"
+)
+
+var errLegacy = errors.New("the fake daemon does not support APIs older than 1.20")
+
+// Daemon is an in-memory volumes.Client, to use the volumes package without a
+// Docker daemon.
+// Volumes only hold regular files and hard links to them, keyed by path
+// relative to the volume. No
+// container actually runs anything, the Run func gets every container that is
+// started instead and can stand in for what its command would have done, eg.
+// the scripts of helper containers.
+type Daemon struct {
+	// APIVersion is reported by Version, defaults to 1.21
+	APIVersion string
+	// Run is called for every container started with RunContainer, with the
+	// daemon locked. Whatever it returns is the output and exit code of the
+	// container.
+	Run func(f *Daemon, c *Container) (stdout, stderr string, exitCode int)
+
+	mu         sync.Mutex
+	nextID     int
+	containers map[string]*Container
+	volumes    map[string]*Volume
+}
+
+// Container is a container of the Daemon, either added with AddContainer or
+// started with RunContainer
+type Container struct {
+	volumes.Container
+	Config map[string]interface{}
+	// Mounts has the host path of the volume at each path in the container
+	Mounts map[string]*volumes.Mount
+
+	stdout, stderr string
+}
+
+// Cmd gets the command the container was started with
+func (c *Container) Cmd() []string {
+	cmd, _ := c.Config["Cmd"].([]string)
+	return cmd
+}
+
+// Volume is a volume of the Daemon
+type Volume struct {
+	Name  string
+	Files map[string][]byte
+	// Links has the hard links, keyed by path, to the file they link to. The
+	// content of a link is in Files as well.
+	Links map[string]string
+}
+
+// Link makes a hard link at name to the file target
+func (v *Volume) Link(target, name string) {
+	v.Files[name] = v.Files[target]
+	v.Links[name] = target
+}
+
+// Remove deletes the file or dir at name along with everything under it
+func (v *Volume) Remove(name string) {
+	for p := range v.Files {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			delete(v.Files, p)
+			delete(v.Links, p)
+		}
+	}
+}
+
+// NewDaemon creates a daemon without any containers or volumes
+func NewDaemon() *Daemon {
+	return &Daemon{
+		APIVersion: "1.21",
+		containers: make(map[string]*Container),
+		volumes:    make(map[string]*Volume),
+	}
+}
+
+func (f *Daemon) genID() string {
+	f.nextID++
+	return fmt.Sprintf("%064x", f.nextID)
+}
+
+// AddVolume creates a volume, named after its ID if name is empty, and returns
+// its host path
+func (f *Daemon) AddVolume(name string, files map[string][]byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addVolume(name, files)
+}
+
+func (f *Daemon) addVolume(name string, files map[string][]byte) string {
+	if name == "" {
+		name = f.genID()
+	}
+	if files == nil {
+		files = make(map[string][]byte)
+	}
+	hostPath := path.Join("/var/lib/docker/volumes", name)
+	f.volumes[hostPath] = &Volume{Name: name, Files: files, Links: make(map[string]string)}
+	return hostPath
+}
+
+// Volume gets the volume at hostPath, nil if there is none
+func (f *Daemon) Volume(hostPath string) *Volume {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.volumes[strings.TrimSuffix(hostPath, "/_data")]
+}
+
+// MountedVolume gets the volume mounted at p in the container, nil if there is
+// none. It is for Run funcs and does not lock the daemon, unlike Volume.
+func (f *Daemon) MountedVolume(c *Container, p string) *Volume {
+	m, exists := c.Mounts[p]
+	if !exists {
+		return nil
+	}
+	return f.volumes[strings.TrimSuffix(m.HostPath, "/_data")]
+}
+
+// DeleteVolume deletes the volume at hostPath, for Run funcs faking `rm`
+func (f *Daemon) DeleteVolume(hostPath string) {
+	delete(f.volumes, strings.TrimSuffix(hostPath, "/_data"))
+}
+
+// AddContainer creates a running container with the volumes, keyed by the
+// path in the container, at the host paths from AddVolume
+func (f *Daemon) AddContainer(name string, mounts map[string]string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := &Container{Mounts: make(map[string]*volumes.Mount)}
+	c.Id = f.genID()
+	c.Name = "/" + name
+	c.State.Running = true
+	for volPath, hostPath := range mounts {
+		c.Mounts[volPath] = &volumes.Mount{HostPath: hostPath + "/_data", VolPath: volPath, IsReadWrite: true}
+	}
+	f.containers[c.Id] = c
+	return c.Id
+}
+
+// StopContainer stops a container from AddContainer
+func (f *Daemon) StopContainer(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.find(id)
+	if err != nil {
+		return err
+	}
+	c.State.Running, c.State.Paused = false, false
+	return nil
+}
+
+func (f *Daemon) HelperImage() string {
+	return volumes.DefaultHelperImage
+}
+
+func (f *Daemon) Version() (*volumes.DaemonVersion, error) {
+	return &volumes.DaemonVersion{ApiVersion: f.APIVersion, Version: "fake"}, nil
+}
+
+func (f *Daemon) FetchAllContainers(all bool) ([]*volumes.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ids []string
+	for id, c := range f.containers {
+		if all || c.State.Running {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var list []*volumes.Container
+	for _, id := range ids {
+		c := f.containers[id].Container
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+func (f *Daemon) FetchContainer(name string) (*volumes.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.find(name)
+	if err != nil {
+		return nil, err
+	}
+	container := c.Container
+	return &container, nil
+}
+
+// find gets a container by ID, unique ID prefix or name
+func (f *Daemon) find(name string) (*Container, error) {
+	if c, exists := f.containers[name]; exists {
+		return c, nil
+	}
+	var found *Container
+	for id, c := range f.containers {
+		if c.Name == "/"+strings.TrimPrefix(name, "/") {
+			return c, nil
+		}
+		if strings.HasPrefix(id, name) {
+			if found != nil {
+				return nil, fmt.Errorf("multiple containers match %s", name)
+			}
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", volumes.ErrNoSuchContainer, name)
+	}
+	return found, nil
+}
+
+func (f *Daemon) ContainerVolumes(container *volumes.Container) (map[string]*volumes.Mount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, exists := f.containers[container.Id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", volumes.ErrNoSuchContainer, container.Id)
+	}
+	vols := make(map[string]*volumes.Mount)
+	for p, v := range c.Mounts {
+		vol := *v
+		vols[p] = &vol
+	}
+	return vols, nil
+}
+
+func (f *Daemon) ListVolumes() ([]volumes.APIVolume, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var list []volumes.APIVolume
+	for hostPath, v := range f.volumes {
+		list = append(list, volumes.APIVolume{Name: v.Name, Driver: "local", Mountpoint: hostPath + "/_data"})
+	}
+	return list, nil
+}
+
+func (f *Daemon) RemoveVolume(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for hostPath, v := range f.volumes {
+		if v.Name != name {
+			continue
+		}
+		for _, c := range f.containers {
+			for _, m := range c.Mounts {
+				if strings.TrimSuffix(m.HostPath, "/_data") == hostPath {
+					return fmt.Errorf("%w: %s is used by %s", volumes.ErrInUse, name, c.Id)
+				}
+			}
+		}
+		delete(f.volumes, hostPath)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", volumes.ErrNotFound, name)
+}
+
+// RunContainer creates the container, with new volumes for `Volumes` which
+// are not bind-mounted, and passes it to Run
+func (f *Daemon) RunContainer(config map[string]interface{}) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := &Container{Config: config, Mounts: make(map[string]*volumes.Mount)}
+	c.Id = f.genID()
+	c.Name = "/fake_" + c.Id[len(c.Id)-12:]
+	c.Image, _ = config["Image"].(string)
+
+	if hostConfig, ok := config["HostConfig"].(map[string]interface{}); ok {
+		binds, _ := hostConfig["Binds"].([]string)
+		for _, b := range binds {
+			parts := strings.Split(b, ":")
+			if len(parts) < 2 {
+				return "", fmt.Errorf("malformed bind: %s", b)
+			}
+			c.Mounts[parts[1]] = &volumes.Mount{
+				HostPath:    parts[0],
+				VolPath:     parts[1],
+				IsReadWrite: len(parts) < 3 || parts[2] != "ro",
+				IsBindMount: true,
+			}
+		}
+	}
+	vols, _ := config["Volumes"].(map[string]struct{})
+	for volPath := range vols {
+		if _, exists := c.Mounts[volPath]; !exists {
+			hostPath := f.addVolume("", nil)
+			c.Mounts[volPath] = &volumes.Mount{HostPath: hostPath + "/_data", VolPath: volPath, IsReadWrite: true}
+		}
+	}
+
+	if f.Run != nil {
+		c.stdout, c.stderr, c.State.ExitCode = f.Run(f, c)
+	}
+	f.containers[c.Id] = c
+	return c.Id, nil
+}
+
+// RemoveContainer removes the container and, if rmVolumes is set, the volumes
+// which were created for it and are not used by any other container
+func (f *Daemon) RemoveContainer(id string, force, rmVolumes bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, exists := f.containers[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", volumes.ErrNoSuchContainer, id)
+	}
+	if c.State.Running && !force {
+		return fmt.Errorf("container %s is running", id)
+	}
+	delete(f.containers, id)
+	if !rmVolumes {
+		return nil
+	}
+
+	inUse := make(map[string]bool)
+	for _, other := range f.containers {
+		for _, m := range other.Mounts {
+			inUse[strings.TrimSuffix(m.HostPath, "/_data")] = true
+		}
+	}
+	for _, m := range c.Mounts {
+		if hostPath := strings.TrimSuffix(m.HostPath, "/_data"); !m.IsBindMount && !inUse[hostPath] {
+			delete(f.volumes, hostPath)
+		}
+	}
+	return nil
+}
+
+func (f *Daemon) ContainerWait(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.containers[id]; !exists {
+		return fmt.Errorf("%w: %s", volumes.ErrNoSuchContainer, id)
+	}
+	return nil
+}
+
+// ContainerLogs returns the output from Run, multiplexed the same way as the
+// daemon does it for containers without a tty
+func (f *Daemon) ContainerLogs(id string, follow, stdout, stderr, timestamps bool, tail int) (io.Reader, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, exists := f.containers[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", volumes.ErrNoSuchContainer, id)
+	}
+	var b bytes.Buffer
+	frame := func(stream byte, data string) {
+		if data == "" {
+			return
+		}
+		hdr := make([]byte, 8)
+		hdr[0] = stream
+		binary.BigEndian.PutUint32(hdr[4:], uint32(len(data)))
+		b.Write(hdr)
+		b.WriteString(data)
+	}
+	if stdout {
+		frame(1, c.stdout)
+	}
+	if stderr {
+		frame(2, c.stderr)
+	}
+	return &b, nil
+}
+
+func (f *Daemon) ContainerPause(id string) error {
+	return f.setPaused(id, true)
+}
+
+func (f *Daemon) ContainerUnpause(id string) error {
+	return f.setPaused(id, false)
+}
+
+func (f *Daemon) setPaused(id string, paused bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.find(id)
+	if err != nil {
+		return err
+	}
+	if !c.State.Running {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	c.State.Paused = paused
+	return nil
+}
+
+// mount gets the volume mounted at p in the container
+func (f *Daemon) mount(id, p string) (*Volume, *volumes.Mount, error) {
+	c, err := f.find(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, exists := c.Mounts[path.Clean(p)]
+	if !exists {
+		return nil, nil, fmt.Errorf("the fake daemon only has archives of volumes, not %s", p)
+	}
+	v, exists := f.volumes[strings.TrimSuffix(m.HostPath, "/_data")]
+	if !exists {
+		return nil, nil, fmt.Errorf("no such volume: %s", m.HostPath)
+	}
+	return v, m, nil
+}
+
+// GetArchive returns a tar archive of the volume mounted at p, with its mount
+// point as the top level dir. Hard links come last, after the files they link
+// to.
+func (f *Daemon) GetArchive(id, p string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, _, err := f.mount(id, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	dirs := map[string]bool{".": true}
+	for name := range v.Files {
+		if _, isLink := v.Links[name]; !isLink {
+			files = append(files, name)
+		}
+		for d := path.Dir(name); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	for d := range dirs {
+		files = append(files, d+"/")
+	}
+	sort.Strings(files)
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	top := path.Base(p)
+	for _, name := range files {
+		if strings.HasSuffix(name, "/") {
+			dir := path.Join(top, name) + "/"
+			if err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data := v.Files[name]
+		hdr := &tar.Header{Name: path.Join(top, name), Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	var links []string
+	for name := range v.Links {
+		links = append(links, name)
+	}
+	sort.Strings(links)
+	for _, name := range links {
+		hdr := &tar.Header{Name: path.Join(top, name), Typeflag: tar.TypeLink, Linkname: path.Join(top, v.Links[name]), Mode: 0644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&b), nil
+}
+
+// PutArchive extracts the regular files, hard links and dirs in the tar
+// archive into the volume mounted at p, everything else is skipped. Files in
+// the way of a dir are replaced, the same as the daemon does.
+func (f *Daemon) PutArchive(id, p string, archive io.Reader) error {
+	tr := tar.NewReader(archive)
+	var entries []*tar.Header
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			files[volumePath(hdr.Name)] = data
+		case tar.TypeLink, tar.TypeDir:
+		default:
+			continue
+		}
+		entries = append(entries, hdr)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, m, err := f.mount(id, p)
+	if err != nil {
+		return err
+	}
+	if !m.IsReadWrite {
+		return fmt.Errorf("%s is mounted read-only", p)
+	}
+	for _, hdr := range entries {
+		name := volumePath(hdr.Name)
+		dir := path.Dir(name)
+		if hdr.Typeflag == tar.TypeDir {
+			dir = name
+		}
+		for d := dir; d != "."; d = path.Dir(d) {
+			if _, isFile := v.Files[d]; isFile {
+				v.Remove(d)
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			v.Link(volumePath(hdr.Linkname), name)
+		case tar.TypeReg, tar.TypeRegA:
+			delete(v.Links, name)
+			v.Files[name] = files[name]
+		}
+	}
+	return nil
+}
+
+// volumePath cleans up a path in an archive for the Files of a Volume
+func volumePath(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "/"))
+}
+
+func (f *Daemon) Copy(id, path string) (io.Reader, error) {
+	return nil, errLegacy
+}
+
+func (f *Daemon) Build(context io.Reader, name string, quiet, rm bool) (io.Reader, error) {
+	return nil, errLegacy
+}
+
+func (f *Daemon) DecodeStream(r io.Reader) []string {
+	var msgs []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		msgs = append(msgs, s.Text())
+	}
+	return msgs
+}
+
+func (f *Daemon) RemoveImage(id string, force, noprune bool) error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+// xattrsScript dumps the xattrs of everything in the volume, POSIX ACLs are
+// stored as xattrs as well. It needs getfattr from the attr package, which
+// busybox does not have.
+const xattrsScript = `cd /.dockervolume && if ! getfattr --version >/dev/null 2>&1; then echo '# no getfattr'; exit 0; fi; getfattr -R -P -d -m - -e base64 . 2>/dev/null; true`
+
+// xattrs that are specific to the host and are not exported
+var skipXattrs = map[string]bool{
+	"security.selinux": true,
+}
+
+// volumeXattrs reads the xattrs of all files in the volume at hostPath with a
+// helper container running the image, keyed by path relative to the volume
+func volumeXattrs(docker Client, image, hostPath string) (map[string]map[string]string, error) {
+	containerConfig := map[string]interface{}{
+		"Image": image,
+		"Cmd":   []string{"/bin/sh", "-c", xattrsScript},
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{hostPath + ":/.dockervolume:ro"},
+		},
+	}
+	out, err := RunHelper(docker, containerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read xattrs: %v", err)
+	}
+	if bytes.HasPrefix(out, []byte("# no getfattr")) {
+		return nil, fmt.Errorf("Could not read xattrs: %s has no getfattr, use an image with the attr package installed", image)
+	}
+	return parseGetfattrDump(out)
+}
+
+// parseGetfattrDump parses the output of `getfattr -d -e base64`
+func parseGetfattrDump(dump []byte) (map[string]map[string]string, error) {
+	var (
+		attrs = make(map[string]map[string]string)
+		file  string
+		s     = bufio.NewScanner(bytes.NewReader(dump))
+	)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# file: "):
+			file = xattrPath(unescapeGetfattr(strings.TrimPrefix(line, "# file: ")))
+		case strings.HasPrefix(line, "#"):
+		default:
+			name, value := line, ""
+			if i := strings.Index(line, "="); i >= 0 {
+				name, value = line[:i], line[i+1:]
+			}
+			if skipXattrs[name] {
+				continue
+			}
+			v, err := decodeGetfattrValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed value for xattr %s of %s: %v", name, file, err)
+			}
+			if attrs[file] == nil {
+				attrs[file] = make(map[string]string)
+			}
+			attrs[file][name] = v
+		}
+	}
+	return attrs, s.Err()
+}
+
+func decodeGetfattrValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "0s"):
+		b, err := base64.StdEncoding.DecodeString(v[2:])
+		return string(b), err
+	case strings.HasPrefix(v, "0x"):
+		b, err := hex.DecodeString(v[2:])
+		return string(b), err
+	case strings.HasPrefix(v, `"`):
+		return strconv.Unquote(v)
+	default:
+		return v, nil
+	}
+}
+
+// unescapeGetfattr undoes the octal escapes getfattr uses for special
+// characters in file names
+func unescapeGetfattr(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// xattrPath gets the key for xattrs of the file, relative to the volume with
+// `.` for the volume itself
+func xattrPath(p string) string {
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "./"), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// setXattrs adds the xattrs to the tar header as PAX records, which is how the
+// daemon expects them when extracting
+func setXattrs(hdr *tar.Header, attrs map[string]string) {
+	if len(attrs) == 0 {
+		return
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string)
+	}
+	for k, v := range attrs {
+		hdr.PAXRecords[paxXattrPrefix+k] = v
+	}
+	hdr.Format = tar.FormatPAX
+}
+
+// xattrsDigest gets a digest of all the xattrs of the entry, so changes to
+// them show up in the manifest
+func xattrsDigest(hdr *tar.Header) string {
+	var keys []string
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, paxXattrPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", strings.TrimPrefix(k, paxXattrPrefix), hdr.PAXRecords[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}